@@ -0,0 +1,213 @@
+package core
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cube-group/pg-replication/util"
+	"github.com/jackc/pgx/pgtype"
+	"github.com/shopspring/decimal"
+)
+
+// ErrNull 列值为NULL
+var ErrNull = errors.New("null value")
+
+// Value 获取Body中的列值，列不存在时返回错误
+func (m ReplicationMessage) Value(column string) (interface{}, error) {
+	value, ok := m.Body[column]
+	if !ok {
+		return nil, fmt.Errorf("%s.%s: column %q not found", m.SchemaName, m.TableName, column)
+	}
+	return value, nil
+}
+
+// IsNull 列值是否为NULL，列不存在时返回false
+func (m ReplicationMessage) IsNull(column string) bool {
+	value, ok := m.Body[column]
+	return ok && value == nil
+}
+
+// 获取非NULL列值，pgtype值(未开启Normalize时的numeric等)转为文本
+func (m ReplicationMessage) scalar(column string) (interface{}, error) {
+	value, err := m.Value(column)
+	if err != nil {
+		return nil, err
+	}
+	switch v := value.(type) {
+	case nil:
+		return nil, m.columnError(column, ErrNull)
+	case *pgtype.Numeric:
+		return numericString(v.Int, v.Exp), nil
+	case pgtype.TextEncoder:
+		buf, err := v.EncodeText(nil, nil)
+		if err != nil {
+			return nil, m.columnError(column, err)
+		}
+		return string(buf), nil
+	}
+	return value, nil
+}
+
+func (m ReplicationMessage) columnError(column string, err error) error {
+	return fmt.Errorf("%s.%s: column %q: %w", m.SchemaName, m.TableName, column, err)
+}
+
+// Int64 获取整数列值，支持数值及数值文本，超出范围返回错误
+func (m ReplicationMessage) Int64(column string) (int64, error) {
+	value, err := m.scalar(column)
+	if err != nil {
+		return 0, err
+	}
+	i, err := util.Int64(value)
+	if err != nil {
+		return 0, m.columnError(column, err)
+	}
+	return i, nil
+}
+
+// Int32 获取整数列值，超出int32范围返回错误
+func (m ReplicationMessage) Int32(column string) (int32, error) {
+	value, err := m.scalar(column)
+	if err != nil {
+		return 0, err
+	}
+	i, err := util.Int32(value)
+	if err != nil {
+		return 0, m.columnError(column, err)
+	}
+	return i, nil
+}
+
+// Float64 获取浮点数列值
+func (m ReplicationMessage) Float64(column string) (float64, error) {
+	value, err := m.scalar(column)
+	if err != nil {
+		return 0, err
+	}
+	f, err := util.Float64(value)
+	if err != nil {
+		return 0, m.columnError(column, err)
+	}
+	return f, nil
+}
+
+// Decimal 获取numeric等列的精确十进制值，money列值为最小货币单位的整数(见Money)
+func (m ReplicationMessage) Decimal(column string) (decimal.Decimal, error) {
+	value, err := m.Value(column)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	if v, ok := value.(*pgtype.Numeric); ok && v.Int != nil {
+		return decimal.NewFromBigInt(v.Int, v.Exp), nil
+	}
+	if value, err = m.scalar(column); err != nil {
+		return decimal.Zero, err
+	}
+	d, err := util.Decimal(value)
+	if err != nil {
+		return decimal.Zero, m.columnError(column, err)
+	}
+	return d, nil
+}
+
+// String 获取列值的文本形式
+func (m ReplicationMessage) String(column string) (string, error) {
+	value, err := m.scalar(column)
+	if err != nil {
+		return "", err
+	}
+	if ts, ok := value.(time.Time); ok {
+		return ts.Format(time.RFC3339Nano), nil
+	}
+	s, err := util.String(value)
+	if err != nil {
+		return "", m.columnError(column, err)
+	}
+	return s, nil
+}
+
+// Bool 获取布尔列值
+func (m ReplicationMessage) Bool(column string) (bool, error) {
+	value, err := m.scalar(column)
+	if err != nil {
+		return false, err
+	}
+	b, err := util.Bool(value)
+	if err != nil {
+		return false, m.columnError(column, err)
+	}
+	return b, nil
+}
+
+// Time 获取date/timestamp/timestamptz列值，infinity返回错误
+func (m ReplicationMessage) Time(column string) (time.Time, error) {
+	value, err := m.Value(column)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if value == nil {
+		return time.Time{}, m.columnError(column, ErrNull)
+	}
+	ts, err := util.Time(value)
+	if err != nil {
+		return time.Time{}, m.columnError(column, err)
+	}
+	return ts, nil
+}
+
+// Bytes 获取bytea列值，Normalize的base64文本及wal2json的"\x"十六进制文本解码为原始字节
+func (m ReplicationMessage) Bytes(column string) ([]byte, error) {
+	value, err := m.Value(column)
+	if err != nil {
+		return nil, err
+	}
+	var b []byte
+	switch v := value.(type) {
+	case nil:
+		return nil, m.columnError(column, ErrNull)
+	case string:
+		if strings.HasPrefix(v, `\x`) {
+			b, err = hex.DecodeString(v[2:])
+		} else {
+			b, err = base64.StdEncoding.DecodeString(v)
+		}
+	default:
+		b, err = util.Bytes(value)
+	}
+	if err != nil {
+		return nil, m.columnError(column, err)
+	}
+	return b, nil
+}
+
+// JSON 将json/jsonb(或JSON文本)列值解析到v
+func (m ReplicationMessage) JSON(column string, v interface{}) error {
+	value, err := m.Value(column)
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		return m.columnError(column, ErrNull)
+	}
+	var data []byte
+	switch raw := value.(type) {
+	case string:
+		data = []byte(raw)
+	case []byte:
+		data = raw
+	default:
+		// pgtype.JSON/JSONB已解析为map等
+		if data, err = json.Marshal(raw); err != nil {
+			return m.columnError(column, err)
+		}
+	}
+	if err = json.Unmarshal(data, v); err != nil {
+		return m.columnError(column, err)
+	}
+	return nil
+}
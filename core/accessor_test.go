@@ -0,0 +1,203 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/pgtype"
+)
+
+func TestAccessorDecimal(t *testing.T) {
+	rs := NewRelationSet()
+	rs.Add(Relation{ID: 1, Columns: []Column{{Name: "n", Type: pgtype.NumericOID}, {Name: "m", Type: moneyOID}}})
+	values, err := rs.Values(1, []Tuple{{Flag: 't', Value: []byte("12.50")}, {Flag: 't', Value: []byte("-$1,000.05")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := ReplicationMessage{SchemaName: "public", TableName: "t", Body: map[string]interface{}{
+		"n": values["n"].Get(), "m": values["m"].Get(), "s": "0.10", "x": "abc", "null": nil,
+	}}
+	for column, want := range map[string]string{"n": "12.5", "m": "-100005", "s": "0.1"} {
+		if got, err := m.Decimal(column); err != nil || got.String() != want {
+			t.Errorf("%s: got %s, %v, want %s", column, got, err, want)
+		}
+	}
+	if _, err := m.Decimal("x"); err == nil {
+		t.Error("x: expected error")
+	}
+	if _, err := m.Decimal("null"); !errors.Is(err, ErrNull) {
+		t.Errorf("null: got %v, want ErrNull", err)
+	}
+}
+
+func TestAccessors(t *testing.T) {
+	ts := time.Date(2023, 1, 2, 3, 4, 5, 123456000, time.UTC)
+	rs := NewRelationSet()
+	rs.Add(Relation{ID: 1, Columns: []Column{{Name: "inf", Type: pgtype.TimestamptzOID}, {Name: "jb", Type: pgtype.JSONBOID}}})
+	values, err := rs.Values(1, []Tuple{{Flag: 't', Value: []byte("infinity")}, {Flag: 't', Value: []byte(`{"a":1}`)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := ReplicationMessage{SchemaName: "public", TableName: "t", Body: map[string]interface{}{
+		"i64":       int64(math.MaxInt64),
+		"u64":       uint64(math.MaxUint64),
+		"big":       "9223372036854775808",
+		"i32":       int64(math.MaxInt32 + 1),
+		"num":       "42",
+		"f":         1.5,
+		"f_big":     1e19,
+		"ts":        ts,
+		"ts_text":   "2023-01-02 11:04:05.123456+08",
+		"inf":       values["inf"].Get(),
+		"inf_text":  "infinity",
+		"b":         true,
+		"b_text":    "f",
+		"jb":        values["jb"].Get(),
+		"json_text": `{"a":2}`,
+		"raw":       []byte{1, 2},
+		"base64":    "AQI=",
+		"hex":       `\x0102`,
+		"bad_hex":   `\xzz`,
+		"null":      nil,
+	}}
+	if got, err := m.Int64("i64"); err != nil || got != math.MaxInt64 {
+		t.Errorf("Int64(i64): got %d, %v", got, err)
+	}
+	if got, err := m.Int64("num"); err != nil || got != 42 {
+		t.Errorf("Int64(num): got %d, %v", got, err)
+	}
+	// 浮点数截断小数部分
+	if got, err := m.Int64("f"); err != nil || got != 1 {
+		t.Errorf("Int64(f): got %d, %v", got, err)
+	}
+	for _, column := range []string{"u64", "big", "f_big"} {
+		if got, err := m.Int64(column); err == nil {
+			t.Errorf("Int64(%s): got %d, want error", column, got)
+		}
+	}
+	if got, err := m.Int32("num"); err != nil || got != 42 {
+		t.Errorf("Int32(num): got %d, %v", got, err)
+	}
+	if got, err := m.Int32("i32"); err == nil {
+		t.Errorf("Int32(i32): got %d, want overflow error", got)
+	}
+	for _, column := range []string{"ts", "ts_text"} {
+		if got, err := m.Time(column); err != nil || !got.Equal(ts) {
+			t.Errorf("Time(%s): got %s, %v", column, got, err)
+		}
+	}
+	for _, column := range []string{"inf", "inf_text", "b"} {
+		if got, err := m.Time(column); err == nil {
+			t.Errorf("Time(%s): got %s, want error", column, got)
+		}
+	}
+	if got, err := m.String("ts"); err != nil || got != "2023-01-02T03:04:05.123456Z" {
+		t.Errorf("String(ts): got %q, %v", got, err)
+	}
+	if got, err := m.String("i64"); err != nil || got != "9223372036854775807" {
+		t.Errorf("String(i64): got %q, %v", got, err)
+	}
+	if got, err := m.Bool("b"); err != nil || !got {
+		t.Errorf("Bool(b): got %v, %v", got, err)
+	}
+	if got, err := m.Bool("b_text"); err != nil || got {
+		t.Errorf("Bool(b_text): got %v, %v", got, err)
+	}
+	if _, err := m.Bool("num"); err == nil {
+		t.Error("Bool(num): expected error")
+	}
+	for column, want := range map[string]float64{"jb": 1, "json_text": 2} {
+		var v struct{ A float64 }
+		if err := m.JSON(column, &v); err != nil || v.A != want {
+			t.Errorf("JSON(%s): got %+v, %v", column, v, err)
+		}
+	}
+	if err := m.JSON("b_text", &struct{}{}); err == nil {
+		t.Error("JSON(b_text): expected error")
+	}
+	for _, column := range []string{"raw", "base64", "hex"} {
+		if got, err := m.Bytes(column); err != nil || !bytes.Equal(got, []byte{1, 2}) {
+			t.Errorf("Bytes(%s): got %v, %v", column, got, err)
+		}
+	}
+	for _, column := range []string{"bad_hex", "b_text", "i64"} {
+		if got, err := m.Bytes(column); err == nil {
+			t.Errorf("Bytes(%s): got %v, want error", column, got)
+		}
+	}
+	// NULL及不存在的列
+	nullErrs := []error{}
+	_, err = m.Int64("null")
+	nullErrs = append(nullErrs, err)
+	_, err = m.Int32("null")
+	nullErrs = append(nullErrs, err)
+	_, err = m.String("null")
+	nullErrs = append(nullErrs, err)
+	_, err = m.Bool("null")
+	nullErrs = append(nullErrs, err)
+	_, err = m.Time("null")
+	nullErrs = append(nullErrs, err)
+	_, err = m.Bytes("null")
+	nullErrs = append(nullErrs, err)
+	nullErrs = append(nullErrs, m.JSON("null", &struct{}{}))
+	for i, err := range nullErrs {
+		if !errors.Is(err, ErrNull) {
+			t.Errorf("accessor %d: got %v, want ErrNull", i, err)
+		}
+	}
+	if !m.IsNull("null") || m.IsNull("missing") || m.IsNull("b") {
+		t.Error("IsNull")
+	}
+	if _, err = m.Int64("missing"); err == nil || errors.Is(err, ErrNull) {
+		t.Errorf("missing column: got %v", err)
+	}
+	if _, err = m.Bytes("missing"); err == nil || errors.Is(err, ErrNull) {
+		t.Errorf("missing column: got %v", err)
+	}
+}
+
+// 开启Normalize后的列值可由访问方法读回原值
+func TestAccessorsNormalized(t *testing.T) {
+	rs := NewRelationSet()
+	rs.Add(Relation{ID: 1, Columns: []Column{
+		{Name: "id", Type: pgtype.Int8OID},
+		{Name: "at", Type: pgtype.TimestamptzOID},
+		{Name: "data", Type: pgtype.ByteaOID},
+		{Name: "ok", Type: pgtype.BoolOID},
+		{Name: "meta", Type: pgtype.JSONBOID},
+	}})
+	values, err := rs.Values(1, []Tuple{
+		{Flag: 't', Value: []byte("9223372036854775807")},
+		{Flag: 't', Value: []byte("2023-01-02 03:04:05.5+08")},
+		{Flag: 't', Value: []byte(`\xdeadbeef`)},
+		{Flag: 't', Value: []byte("t")},
+		{Flag: 't', Value: []byte(`{"a": [1]}`)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := make(map[string]interface{})
+	for name, value := range values {
+		body[name] = Normalize(value)
+	}
+	m := ReplicationMessage{Body: body}
+	if got, err := m.Int64("id"); err != nil || got != math.MaxInt64 {
+		t.Errorf("Int64: got %d, %v", got, err)
+	}
+	if got, err := m.Time("at"); err != nil || !got.Equal(time.Date(2023, 1, 1, 19, 4, 5, 5e8, time.UTC)) {
+		t.Errorf("Time: got %s, %v", got, err)
+	}
+	if got, err := m.Bytes("data"); err != nil || !bytes.Equal(got, []byte{0xde, 0xad, 0xbe, 0xef}) {
+		t.Errorf("Bytes: got %x, %v", got, err)
+	}
+	if got, err := m.Bool("ok"); err != nil || !got {
+		t.Errorf("Bool: got %v, %v", got, err)
+	}
+	var meta struct{ A []int }
+	if err := m.JSON("meta", &meta); err != nil || len(meta.A) != 1 || meta.A[0] != 1 {
+		t.Errorf("JSON: got %+v, %v", meta, err)
+	}
+}
@@ -0,0 +1,200 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/pgtype"
+)
+
+// pgtype v3未实现的内置类型
+// time/timetz的值为PostgreSQL文本格式的字符串，二进制格式解码后转为与文本格式一致的字符串
+
+// Money money类型，值为以最小货币单位(如分)计的整数(int64)，与服务端lc_monetary无关
+// 二进制格式即为该整数；文本格式(如"$1,000.00"、"-1.000,00 €")总是输出全部小数位，去除货币符号及分隔符后即为该整数
+type Money struct {
+	pgtype.Int8
+}
+
+func (dst *Money) DecodeText(ci *pgtype.ConnInfo, src []byte) error {
+	if src == nil {
+		*dst = Money{pgtype.Int8{Status: pgtype.Null}}
+		return nil
+	}
+	n, err := moneyMinorUnits(string(src))
+	if err != nil {
+		return err
+	}
+	*dst = Money{pgtype.Int8{Int: n, Status: pgtype.Present}}
+	return nil
+}
+
+// money文本中的数字即为最小货币单位的值，负数为"-"或括号
+func moneyMinorUnits(s string) (int64, error) {
+	digits := make([]byte, 1, len(s)+1)
+	digits[0] = '+'
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c >= '0' && c <= '9':
+			digits = append(digits, c)
+		case c == '-' || c == '(':
+			digits[0] = '-'
+		}
+	}
+	if len(digits) == 1 {
+		return 0, fmt.Errorf("invalid money %q", s)
+	}
+	n, err := strconv.ParseInt(string(digits), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid money %q: %s", s, err)
+	}
+	return n, nil
+}
+
+// Time time类型，如"04:05:06.789"
+type Time struct {
+	pgtype.Text
+}
+
+func (dst *Time) DecodeBinary(ci *pgtype.ConnInfo, src []byte) error {
+	if src == nil {
+		*dst = Time{pgtype.Text{Status: pgtype.Null}}
+		return nil
+	}
+	if len(src) != 8 {
+		return fmt.Errorf("invalid length for time: %v", len(src))
+	}
+	*dst = Time{pgtype.Text{String: formatMicroseconds(int64(binary.BigEndian.Uint64(src))), Status: pgtype.Present}}
+	return nil
+}
+
+// Timetz timetz类型，如"04:05:06.789+08"
+type Timetz struct {
+	pgtype.Text
+}
+
+func (dst *Timetz) DecodeBinary(ci *pgtype.ConnInfo, src []byte) error {
+	if src == nil {
+		*dst = Timetz{pgtype.Text{Status: pgtype.Null}}
+		return nil
+	}
+	if len(src) != 12 {
+		return fmt.Errorf("invalid length for timetz: %v", len(src))
+	}
+	// 时区为UTC以西的秒数
+	zone := -int32(binary.BigEndian.Uint32(src[8:]))
+	sign := byte('+')
+	if zone < 0 {
+		sign, zone = '-', -zone
+	}
+	var sb strings.Builder
+	sb.WriteString(formatMicroseconds(int64(binary.BigEndian.Uint64(src))))
+	sb.WriteByte(sign)
+	fmt.Fprintf(&sb, "%02d", zone/3600)
+	if zone%3600 != 0 {
+		fmt.Fprintf(&sb, ":%02d", zone%3600/60)
+		if zone%60 != 0 {
+			fmt.Fprintf(&sb, ":%02d", zone%60)
+		}
+	}
+	*dst = Timetz{pgtype.Text{String: sb.String(), Status: pgtype.Present}}
+	return nil
+}
+
+// 当天的微秒数格式化为HH:MM:SS[.ffffff]，与PostgreSQL文本格式一致
+func formatMicroseconds(us int64) string {
+	s := fmt.Sprintf("%02d:%02d:%02d", us/3600000000, us/60000000%60, us/1000000%60)
+	if frac := us % 1000000; frac != 0 {
+		s += "." + strings.TrimRight(fmt.Sprintf("%06d", frac), "0")
+	}
+	return s
+}
+
+// numeric的NaN及Infinity(PG14+)无法用pgtype.Numeric表示(文本格式解码失败，二进制格式被解码为0)
+// 列值(或数组元素)含这些值时按文本返回，numeric为pgtype.Text，numeric[]为pgtype.TextArray
+func numericSpecial(decoder DecoderValue, tuple Tuple) (DecoderValue, bool) {
+	switch decoder.(type) {
+	case *pgtype.Numeric:
+		if tuple.Flag == 'b' {
+			if s, ok := numericSpecialBinary(tuple.Value); ok {
+				return &pgtype.Text{String: s, Status: pgtype.Present}, true
+			}
+			return nil, false
+		}
+		switch s := string(tuple.Value); s {
+		case "NaN", "Infinity", "-Infinity":
+			return &pgtype.Text{String: s, Status: pgtype.Present}, true
+		}
+	case *pgtype.NumericArray:
+		if tuple.Flag == 'b' {
+			return numericSpecialArray(tuple.Value)
+		}
+		if bytes.Contains(tuple.Value, []byte("NaN")) || bytes.Contains(tuple.Value, []byte("Infinity")) {
+			array := &pgtype.TextArray{}
+			if err := array.DecodeText(nil, tuple.Value); err == nil {
+				return array, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// 二进制格式：ndigits为0，sign为0xC000(NaN)、0xD000(Infinity)或0xF000(-Infinity)
+func numericSpecialBinary(src []byte) (string, bool) {
+	if len(src) < 8 || binary.BigEndian.Uint16(src) != 0 {
+		return "", false
+	}
+	switch binary.BigEndian.Uint16(src[4:]) {
+	case 0xC000:
+		return "NaN", true
+	case 0xD000:
+		return "Infinity", true
+	case 0xF000:
+		return "-Infinity", true
+	}
+	return "", false
+}
+
+// 二进制格式的numeric[]含NaN/Infinity时，逐个元素转为文本
+func numericSpecialArray(src []byte) (DecoderValue, bool) {
+	var header pgtype.ArrayHeader
+	rp, err := header.DecodeBinary(nil, src)
+	if err != nil {
+		return nil, false
+	}
+	var elements []pgtype.Text
+	special := false
+	for rp < len(src) {
+		if len(src[rp:]) < 4 {
+			return nil, false
+		}
+		size := int32(binary.BigEndian.Uint32(src[rp:]))
+		rp += 4
+		if size < 0 {
+			elements = append(elements, pgtype.Text{Status: pgtype.Null})
+			continue
+		}
+		if len(src[rp:]) < int(size) {
+			return nil, false
+		}
+		elem := src[rp : rp+int(size)]
+		rp += int(size)
+		if s, ok := numericSpecialBinary(elem); ok {
+			special = true
+			elements = append(elements, pgtype.Text{String: s, Status: pgtype.Present})
+			continue
+		}
+		var n pgtype.Numeric
+		if err = n.DecodeBinary(nil, elem); err != nil {
+			return nil, false
+		}
+		elements = append(elements, pgtype.Text{String: numericString(n.Int, n.Exp), Status: pgtype.Present})
+	}
+	if !special {
+		return nil, false
+	}
+	return &pgtype.TextArray{Elements: elements, Dimensions: header.Dimensions, Status: pgtype.Present}, true
+}
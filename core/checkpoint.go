@@ -0,0 +1,246 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/pgtype"
+)
+
+// Checkpoint 复制进度
+type Checkpoint struct {
+	Lsn        uint64            `json:"lsn"`
+	Xid        uint32            `json:"xid,omitempty"`         //最后确认的事务
+	CommitTime time.Time         `json:"commit_time,omitempty"` //最后确认事务的提交时间
+	SavedAt    time.Time         `json:"saved_at"`
+	Meta       map[string]string `json:"meta,omitempty"` //自定义信息，见Replication.CheckpointMeta
+}
+
+// CheckpointStore 复制进度存储
+// 开始复制时从Load的lsn继续；每次确认lsn前先Save，保存失败则不确认
+type CheckpointStore interface {
+	// Load 读取复制槽的进度，无记录时返回nil
+	Load(slot string) (*Checkpoint, error)
+	// Save 保存复制槽的进度
+	Save(slot string, checkpoint Checkpoint) error
+}
+
+// Checkpoint 设置复制进度存储
+// 服务端会忽略早于复制槽confirmed_flush_lsn的起始lsn，回退到更早的位置需重建复制槽
+func (t *Replication) Checkpoint(store CheckpointStore) *Replication {
+	t.checkpoint = store
+	return t
+}
+
+// CheckpointMeta 设置随进度一并保存的自定义信息
+func (t *Replication) CheckpointMeta(meta map[string]string) *Replication {
+	t.checkpointMeta = meta
+	return t
+}
+
+// 读取保存的进度作为起始lsn
+func (t *Replication) loadCheckpoint() error {
+	if t.checkpoint == nil {
+		return nil
+	}
+	checkpoint, err := t.checkpoint.Load(t.name)
+	if err != nil {
+		return fmt.Errorf("load checkpoint: %s", err)
+	}
+	if checkpoint != nil && checkpoint.Lsn > t._ackLsn {
+		t._ackLsn = checkpoint.Lsn
+		t.debug("checkpoint lsn:", checkpoint.Lsn, pgx.FormatLSN(checkpoint.Lsn))
+	}
+	return nil
+}
+
+// 保存进度，未设置存储时忽略
+func (t *Replication) saveCheckpoint(checkpoint Checkpoint) error {
+	if t.checkpoint == nil || checkpoint.Lsn == 0 {
+		return nil
+	}
+	checkpoint.SavedAt = time.Now()
+	checkpoint.Meta = t.checkpointMeta
+	if err := t.checkpoint.Save(t.name, checkpoint); err != nil {
+		return fmt.Errorf("save checkpoint %s: %s", pgx.FormatLSN(checkpoint.Lsn), err)
+	}
+	return nil
+}
+
+// FileCheckpointStore 文件存储，每个复制槽一个JSON文件(Dir/slot.json)
+// 先写入临时文件再重命名，保证文件内容完整
+type FileCheckpointStore struct {
+	Dir string
+}
+
+func (s *FileCheckpointStore) path(slot string) string {
+	return filepath.Join(s.Dir, slot+".json")
+}
+
+func (s *FileCheckpointStore) Load(slot string) (*Checkpoint, error) {
+	data, err := os.ReadFile(s.path(slot))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var checkpoint Checkpoint
+	if err = json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, fmt.Errorf("%s: %s", s.path(slot), err)
+	}
+	return &checkpoint, nil
+}
+
+func (s *FileCheckpointStore) Save(slot string, checkpoint Checkpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.Dir, slot+".json.*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), s.path(slot)); err != nil {
+		return err
+	}
+	// 同步目录，确保重命名已落盘
+	if dir, err := os.Open(s.Dir); err == nil {
+		_ = dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+// TableCheckpointStore PostgreSQL表存储，表不存在时自动创建
+// 通常为下游(sink)数据库，与其写入保持一致；若与复制源为同一数据库，
+// 需将该表排除在发布之外，否则保存进度产生的变更会再次被复制
+type TableCheckpointStore struct {
+	Config pgx.ConnConfig
+	Table  string //默认replication_checkpoint，可为schema.table
+
+	mu    sync.Mutex
+	conn  *pgx.Conn
+	ready bool
+}
+
+func (s *TableCheckpointStore) table() string {
+	table := s.Table
+	if table == "" {
+		table = "replication_checkpoint"
+	}
+	return pgx.Identifier(strings.Split(table, ".")).Sanitize()
+}
+
+// 获取连接并确保表存在
+func (s *TableCheckpointStore) open() (*pgx.Conn, error) {
+	if s.conn == nil || !s.conn.IsAlive() {
+		conn, err := pgx.Connect(s.Config)
+		if err != nil {
+			return nil, err
+		}
+		s.conn = conn
+	}
+	if !s.ready {
+		if _, err := s.conn.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+slot text PRIMARY KEY, lsn bigint NOT NULL, xid bigint NOT NULL DEFAULT 0, commit_time timestamptz,
+saved_at timestamptz NOT NULL, meta jsonb)`, s.table())); err != nil {
+			return nil, err
+		}
+		s.ready = true
+	}
+	return s.conn, nil
+}
+
+func (s *TableCheckpointStore) Load(slot string) (*Checkpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conn, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		lsn, xid   int64
+		commitTime pgtype.Timestamptz
+		checkpoint Checkpoint
+		meta       string
+	)
+	err = conn.QueryRow(fmt.Sprintf(`SELECT lsn, xid, commit_time, saved_at, coalesce(meta::text, '') FROM %s WHERE slot = $1`, s.table()), slot).
+		Scan(&lsn, &xid, &commitTime, &checkpoint.SavedAt, &meta)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	checkpoint.Lsn, checkpoint.Xid = uint64(lsn), uint32(xid)
+	if commitTime.Status == pgtype.Present {
+		checkpoint.CommitTime = commitTime.Time
+	}
+	if len(meta) > 0 {
+		if err = json.Unmarshal([]byte(meta), &checkpoint.Meta); err != nil {
+			return nil, err
+		}
+	}
+	return &checkpoint, nil
+}
+
+func (s *TableCheckpointStore) Save(slot string, checkpoint Checkpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conn, err := s.open()
+	if err != nil {
+		return err
+	}
+	var meta *string
+	if checkpoint.Meta != nil {
+		data, err := json.Marshal(checkpoint.Meta)
+		if err != nil {
+			return err
+		}
+		text := string(data)
+		meta = &text
+	}
+	commitTime := pgtype.Timestamptz{Status: pgtype.Null}
+	if !checkpoint.CommitTime.IsZero() {
+		commitTime = pgtype.Timestamptz{Time: checkpoint.CommitTime, Status: pgtype.Present}
+	}
+	_, err = conn.Exec(fmt.Sprintf(`INSERT INTO %s (slot, lsn, xid, commit_time, saved_at, meta) VALUES ($1, $2, $3, $4, $5, $6::jsonb)
+ON CONFLICT (slot) DO UPDATE SET lsn = excluded.lsn, xid = excluded.xid, commit_time = excluded.commit_time,
+saved_at = excluded.saved_at, meta = excluded.meta`, s.table()),
+		slot, int64(checkpoint.Lsn), int64(checkpoint.Xid), &commitTime, checkpoint.SavedAt, meta)
+	return err
+}
+
+// Close 关闭数据库连接
+func (s *TableCheckpointStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
@@ -0,0 +1,139 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFileCheckpointStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "checkpoints")
+	s := &FileCheckpointStore{Dir: dir}
+	// 目录及文件不存在
+	if checkpoint, err := s.Load("test_slot"); err != nil || checkpoint != nil {
+		t.Fatalf("missing file: got %+v, %v", checkpoint, err)
+	}
+	want := Checkpoint{
+		Lsn:        0x16B3748,
+		Xid:        529,
+		CommitTime: time.Date(2023, 1, 2, 3, 4, 5, 123456000, time.UTC),
+		SavedAt:    time.Date(2023, 1, 2, 3, 4, 6, 0, time.UTC),
+		Meta:       map[string]string{"sink": "es"},
+	}
+	for _, lsn := range []uint64{1, want.Lsn} {
+		checkpoint := want
+		checkpoint.Lsn = lsn
+		if err := s.Save("test_slot", checkpoint); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := s.Load("test_slot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.Lsn != want.Lsn || got.Xid != want.Xid || !got.CommitTime.Equal(want.CommitTime) ||
+		!got.SavedAt.Equal(want.SavedAt) || !reflect.DeepEqual(got.Meta, want.Meta) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	// 临时文件已重命名或删除
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "test_slot.json" {
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		t.Errorf("files %v, want only test_slot.json", names)
+	}
+	if err = os.WriteFile(filepath.Join(dir, "broken.json"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Load("broken"); err == nil {
+		t.Error("expected error for malformed file")
+	}
+}
+
+// 记录保存时已确认的lsn
+type recordingCheckpointStore struct {
+	conn   *fakeConn
+	load   *Checkpoint
+	saves  []Checkpoint
+	acked  []int //每次保存时fakeConn已确认的次数
+	loaded int
+}
+
+func (s *recordingCheckpointStore) Load(slot string) (*Checkpoint, error) {
+	s.loaded++
+	return s.load, nil
+}
+
+func (s *recordingCheckpointStore) Save(slot string, checkpoint Checkpoint) error {
+	s.saves = append(s.saves, checkpoint)
+	s.acked = append(s.acked, len(s.conn.acks))
+	return nil
+}
+
+func TestAckSavesCheckpointFirst(t *testing.T) {
+	r, conn := testReplication()
+	store := &recordingCheckpointStore{conn: conn}
+	commitTime := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	r.Checkpoint(store).CheckpointMeta(map[string]string{"sink": "es"})
+	if err := r.ack(ReplicationMessage{EventType: EventType_COMMIT, Lsn: 10, Xid: 7, Tx: &Transaction{Xid: 7, CommitTime: commitTime}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.saves) != 1 || store.acked[0] != 0 {
+		t.Fatalf("saves %+v, acked before save %v, want one save before the status", store.saves, store.acked)
+	}
+	saved := store.saves[0]
+	if saved.Lsn != 10 || saved.Xid != 7 || !saved.CommitTime.Equal(commitTime) || saved.SavedAt.IsZero() || saved.Meta["sink"] != "es" {
+		t.Errorf("saved %+v", saved)
+	}
+	if !reflect.DeepEqual(conn.acks, []uint64{10}) || r._ackLsn != 10 {
+		t.Errorf("acks %v, ack lsn %d", conn.acks, r._ackLsn)
+	}
+
+	// 保存失败不确认
+	r, conn = testReplication()
+	r.Checkpoint(failingCheckpointStore{})
+	if err := r.ack(ReplicationMessage{EventType: EventType_COMMIT, Lsn: 10}); err == nil {
+		t.Fatal("expected save error")
+	}
+	if len(conn.acks) != 0 || r._ackLsn != 0 {
+		t.Errorf("acks %v, ack lsn %d after failed save", conn.acks, r._ackLsn)
+	}
+}
+
+func TestLoadCheckpoint(t *testing.T) {
+	r, conn := testReplication()
+	store := &recordingCheckpointStore{conn: conn}
+	r.Checkpoint(store)
+	// 无记录
+	if err := r.loadCheckpoint(); err != nil || r._ackLsn != 0 || store.loaded != 1 {
+		t.Fatalf("no checkpoint: ack lsn %d, %v", r._ackLsn, err)
+	}
+	store.load = &Checkpoint{Lsn: 42}
+	if err := r.loadCheckpoint(); err != nil || r._ackLsn != 42 {
+		t.Fatalf("ack lsn %d, %v, want 42", r._ackLsn, err)
+	}
+	// 不回退到已确认的lsn之前
+	r._ackLsn = 100
+	if err := r.loadCheckpoint(); err != nil || r._ackLsn != 100 {
+		t.Errorf("ack lsn %d, %v, want 100", r._ackLsn, err)
+	}
+	r.Checkpoint(errorCheckpointStore{})
+	if err := r.loadCheckpoint(); err == nil {
+		t.Error("expected load error")
+	}
+}
+
+type errorCheckpointStore struct{}
+
+func (errorCheckpointStore) Load(slot string) (*Checkpoint, error) {
+	return nil, errors.New("permission denied")
+}
+func (errorCheckpointStore) Save(slot string, checkpoint Checkpoint) error { return nil }
@@ -0,0 +1,218 @@
+package core
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cube-group/pg-replication/util"
+	"github.com/jackc/pgx/pgtype"
+)
+
+// Decode 将变更消息的新行(Body)解码为结构体T
+// 列名通过`pg:"name"`标签映射，未设置标签时按字段名(忽略大小写)匹配，`pg:"-"`忽略该字段
+// 嵌入结构体的字段展开匹配(嵌入指针需为导出类型才能分配)；NULL值对指针字段置nil，其余字段保持零值
+// 整数/浮点数按目标类型转换并检查溢出，数值字符串按目标类型解析，numeric/interval/数组等pgtype值通过AssignTo赋值
+// time.Time字段可由RFC3339及PostgreSQL格式的时间文本(Normalize、wal2json)赋值，infinity返回错误
+func Decode[T any](m ReplicationMessage) (T, error) {
+	var res T
+	if m.Body == nil {
+		return res, fmt.Errorf("decode %s.%s: message has no row", m.SchemaName, m.TableName)
+	}
+	err := decodeRow(m.Body, reflect.ValueOf(&res).Elem())
+	if err != nil {
+		err = fmt.Errorf("decode %s.%s: %s", m.SchemaName, m.TableName, err)
+	}
+	return res, err
+}
+
+// DecodeOld 将变更消息的旧行(OldBody)解码为结构体T，OldKeyOnly时仅复制标识列有值
+func DecodeOld[T any](m ReplicationMessage) (T, error) {
+	var res T
+	if m.OldBody == nil {
+		return res, fmt.Errorf("decode %s.%s: message has no old row", m.SchemaName, m.TableName)
+	}
+	err := decodeRow(m.OldBody, reflect.ValueOf(&res).Elem())
+	if err != nil {
+		err = fmt.Errorf("decode %s.%s: %s", m.SchemaName, m.TableName, err)
+	}
+	return res, err
+}
+
+func decodeRow(body map[string]interface{}, dst reflect.Value) error {
+	if dst.Kind() != reflect.Struct {
+		return fmt.Errorf("unsupported type %s, expected struct", dst.Type())
+	}
+	rt := dst.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		tag, tagged := field.Tag.Lookup("pg")
+		if tag == "-" {
+			continue
+		}
+		fv := dst.Field(i)
+		if field.Anonymous && !tagged {
+			// 嵌入结构体(含指针)展开匹配
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct && ft != timeType {
+				if fv.Kind() == reflect.Ptr {
+					if !fv.CanSet() {
+						continue
+					}
+					if fv.IsNil() {
+						fv.Set(reflect.New(ft))
+					}
+					fv = fv.Elem()
+				}
+				if err := decodeRow(body, fv); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		name := tag
+		if name == "" {
+			name = field.Name
+		}
+		value, ok := body[name]
+		if !ok && !tagged {
+			for column, v := range body {
+				if strings.EqualFold(column, name) {
+					name, value, ok = column, v, true
+					break
+				}
+			}
+		}
+		if !ok {
+			continue
+		}
+		if err := assign(fv, value); err != nil {
+			return fmt.Errorf("column %q into field %s: %s", name, field.Name, err)
+		}
+	}
+	return nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// 将列值赋给字段
+func assign(dst reflect.Value, value interface{}) error {
+	if value == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+	src := reflect.ValueOf(value)
+	if src.Type().AssignableTo(dst.Type()) {
+		dst.Set(src)
+		return nil
+	}
+	// *pgtype.Numeric等赋给pgtype.Numeric字段
+	if src.Kind() == reflect.Ptr && src.Type().Elem().AssignableTo(dst.Type()) {
+		dst.Set(src.Elem())
+		return nil
+	}
+	if dst.Kind() == reflect.Ptr {
+		elem := reflect.New(dst.Type().Elem())
+		if err := assign(elem.Elem(), value); err != nil {
+			return err
+		}
+		dst.Set(elem)
+		return nil
+	}
+	if dst.Kind() == reflect.Interface {
+		return mismatch(value, dst)
+	}
+	// Normalize及wal2json的时间为文本
+	if dst.Type() == timeType {
+		ts, err := util.Time(value)
+		if err != nil {
+			return err
+		}
+		dst.Set(reflect.ValueOf(ts))
+		return nil
+	}
+	if v, ok := value.(pgtype.Value); ok {
+		return v.AssignTo(dst.Addr().Interface())
+	}
+	switch dst.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch src.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if dst.OverflowInt(src.Int()) {
+				return fmt.Errorf("value %d overflows %s", src.Int(), dst.Type())
+			}
+			dst.SetInt(src.Int())
+			return nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if src.Uint() > 1<<63-1 || dst.OverflowInt(int64(src.Uint())) {
+				return fmt.Errorf("value %d overflows %s", src.Uint(), dst.Type())
+			}
+			dst.SetInt(int64(src.Uint()))
+			return nil
+		case reflect.String:
+			i, err := strconv.ParseInt(src.String(), 10, dst.Type().Bits())
+			if err != nil {
+				return err
+			}
+			dst.SetInt(i)
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		switch src.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if src.Int() < 0 || dst.OverflowUint(uint64(src.Int())) {
+				return fmt.Errorf("value %d overflows %s", src.Int(), dst.Type())
+			}
+			dst.SetUint(uint64(src.Int()))
+			return nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if dst.OverflowUint(src.Uint()) {
+				return fmt.Errorf("value %d overflows %s", src.Uint(), dst.Type())
+			}
+			dst.SetUint(src.Uint())
+			return nil
+		case reflect.String:
+			u, err := strconv.ParseUint(src.String(), 10, dst.Type().Bits())
+			if err != nil {
+				return err
+			}
+			dst.SetUint(u)
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		switch src.Kind() {
+		case reflect.Float32, reflect.Float64:
+			dst.SetFloat(src.Float())
+			return nil
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			dst.SetFloat(float64(src.Int()))
+			return nil
+		case reflect.String:
+			// wal2json/test_decoding的numeric为字符串
+			f, err := strconv.ParseFloat(src.String(), dst.Type().Bits())
+			if err != nil {
+				return err
+			}
+			dst.SetFloat(f)
+			return nil
+		}
+	case reflect.String:
+		if src.Kind() == reflect.String {
+			dst.SetString(src.String())
+			return nil
+		}
+	}
+	return mismatch(value, dst)
+}
+
+func mismatch(value interface{}, dst reflect.Value) error {
+	return fmt.Errorf("cannot assign %T to %s", value, dst.Type())
+}
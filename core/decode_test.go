@@ -0,0 +1,119 @@
+package core
+
+import (
+	"math"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/pgtype"
+)
+
+type decodeBase struct {
+	ID int64 `pg:"id"`
+}
+
+type DecodeAudit struct {
+	CreatedAt time.Time  `pg:"created_at"`
+	UpdatedAt *time.Time `pg:"updated_at"`
+}
+
+type decodeUser struct {
+	decodeBase
+	*DecodeAudit
+	Name   string
+	Email  *string `pg:"email"`
+	Age    int8
+	Count  uint16
+	Score  float32
+	Amount pgtype.Numeric `pg:"amount"`
+	Ratio  float64        `pg:"ratio"`
+	Tags   []string       `pg:"tags"`
+	Skip   string         `pg:"-"`
+	hidden string
+}
+
+func TestDecode(t *testing.T) {
+	ts := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	numeric := &pgtype.Numeric{Int: big.NewInt(1250), Exp: -2, Status: pgtype.Present}
+	tags := &pgtype.TextArray{}
+	_ = tags.DecodeText(nil, []byte("{a,b}"))
+	tests := []struct {
+		name  string
+		body  map[string]interface{}
+		check func(r decodeUser) bool
+		ok    bool
+	}{
+		{"tag and field name", map[string]interface{}{"id": int32(1), "name": "a", "age": int16(30)}, func(r decodeUser) bool {
+			return r.ID == 1 && r.Name == "a" && r.Age == 30
+		}, true},
+		{"case insensitive field name", map[string]interface{}{"NAME": "a", "SCORE": 1.5}, func(r decodeUser) bool {
+			return r.Name == "a" && r.Score == 1.5
+		}, true},
+		{"tag is exact", map[string]interface{}{"EMAIL": "x", "ID": int64(1)}, func(r decodeUser) bool {
+			return r.Email == nil && r.ID == 0
+		}, true},
+		{"skipped and unexported", map[string]interface{}{"Skip": "x", "hidden": "x", "-": "x"}, func(r decodeUser) bool {
+			return r.Skip == "" && r.hidden == ""
+		}, true},
+		{"pointer embedded", map[string]interface{}{"created_at": ts, "updated_at": ts}, func(r decodeUser) bool {
+			return r.DecodeAudit != nil && r.CreatedAt.Equal(ts) && r.UpdatedAt != nil && r.UpdatedAt.Equal(ts)
+		}, true},
+		{"time text", map[string]interface{}{"created_at": "2023-01-02T03:04:05Z", "updated_at": "2023-01-02 11:04:05+08"}, func(r decodeUser) bool {
+			return r.DecodeAudit != nil && r.CreatedAt.Equal(ts) && r.UpdatedAt != nil && r.UpdatedAt.Equal(ts)
+		}, true},
+		{"time infinity", map[string]interface{}{"created_at": "infinity"}, nil, false},
+		{"null", map[string]interface{}{"email": nil, "Age": nil, "updated_at": nil}, func(r decodeUser) bool {
+			return r.Email == nil && r.Age == 0 && r.DecodeAudit != nil && r.UpdatedAt == nil
+		}, true},
+		{"pointer", map[string]interface{}{"email": "a@b.c"}, func(r decodeUser) bool {
+			return r.Email != nil && *r.Email == "a@b.c"
+		}, true},
+		{"numeric string", map[string]interface{}{"Age": "-12", "Count": "65535", "Score": "0.5"}, func(r decodeUser) bool {
+			return r.Age == -12 && r.Count == 65535 && r.Score == 0.5
+		}, true},
+		{"int overflow", map[string]interface{}{"Age": int64(128)}, nil, false},
+		{"int string overflow", map[string]interface{}{"Age": "300"}, nil, false},
+		{"uint negative", map[string]interface{}{"Count": int32(-1)}, nil, false},
+		{"uint overflow", map[string]interface{}{"Count": uint32(math.MaxUint16 + 1)}, nil, false},
+		{"int64 from uint64 overflow", map[string]interface{}{"id": uint64(math.MaxUint64)}, nil, false},
+		{"numeric value", map[string]interface{}{"amount": numeric}, func(r decodeUser) bool {
+			return r.Amount.Int.Int64() == 1250 && r.Amount.Exp == -2
+		}, true},
+		{"numeric via AssignTo", map[string]interface{}{"ratio": numeric}, func(r decodeUser) bool {
+			return r.Ratio == 12.5
+		}, true},
+		{"array via AssignTo", map[string]interface{}{"tags": tags}, func(r decodeUser) bool {
+			return len(r.Tags) == 2 && r.Tags[1] == "b"
+		}, true},
+		{"mismatch", map[string]interface{}{"name": 1}, nil, false},
+	}
+	for _, tt := range tests {
+		r, err := Decode[decodeUser](ReplicationMessage{SchemaName: "public", TableName: "t", Body: tt.body})
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: error %v, want ok %v", tt.name, err, tt.ok)
+			continue
+		}
+		if tt.ok && !tt.check(r) {
+			t.Errorf("%s: got %+v", tt.name, r)
+		}
+	}
+}
+
+func TestDecodeNoRow(t *testing.T) {
+	m := ReplicationMessage{SchemaName: "public", TableName: "t", EventType: EventType_TRUNCATE}
+	if _, err := Decode[decodeUser](m); err == nil {
+		t.Error("Decode: expected error for message without row")
+	}
+	if _, err := DecodeOld[decodeUser](m); err == nil {
+		t.Error("DecodeOld: expected error for message without old row")
+	}
+	m.Body = map[string]interface{}{"id": int64(2)}
+	m.OldBody = map[string]interface{}{"id": int64(1)}
+	if r, err := DecodeOld[decodeUser](m); err != nil || r.ID != 1 {
+		t.Errorf("DecodeOld: got %+v, %v", r, err)
+	}
+	if _, err := Decode[int](m); err == nil {
+		t.Error("expected error for non-struct type")
+	}
+}
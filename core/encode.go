@@ -0,0 +1,246 @@
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// encoder 按pgoutput协议写入消息字段，与decoder互逆
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) uint8(x uint8) {
+	e.buf = append(e.buf, x)
+}
+
+func (e *encoder) bool(x bool) {
+	if x {
+		e.uint8(1)
+	} else {
+		e.uint8(0)
+	}
+}
+
+func (e *encoder) uint16(x uint16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], x)
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *encoder) uint32(x uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], x)
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *encoder) uint64(x uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], x)
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *encoder) string(s string) {
+	e.buf = append(e.buf, s...)
+	e.buf = append(e.buf, 0)
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uint32(uint32(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// 自PostgreSQL epoch的微秒数，零值时间同样按实际偏移写入，解析后仍为零值
+func (e *encoder) timestamp(ts time.Time) {
+	e.uint64(uint64((ts.Unix()-pgEpochUnix)*1000000 + int64(ts.Nanosecond()/1000)))
+}
+
+// Insert/Update新元组标识
+func (e *encoder) new(x bool) {
+	if x {
+		e.uint8('N')
+	} else {
+		e.uint8(0)
+	}
+}
+
+// 流式事务中的消息带有xid
+func (e *encoder) xid(xid uint32) {
+	if xid != 0 {
+		e.uint32(xid)
+	}
+}
+
+func (e *encoder) tupledata(data []Tuple) error {
+	e.uint16(uint16(len(data)))
+	for i, tuple := range data {
+		switch tuple.Flag {
+		case 0, 'n':
+			e.uint8('n')
+		case 'u':
+			e.uint8('u')
+		case 't', 'b':
+			e.uint8(uint8(tuple.Flag))
+			e.bytes(tuple.Value)
+		default:
+			return fmt.Errorf("unknown tuple data flag %q at %d", tuple.Flag, i)
+		}
+	}
+	return nil
+}
+
+func (e *encoder) columns(data []Column) {
+	e.uint16(uint16(len(data)))
+	for _, col := range data {
+		e.bool(col.Key)
+		e.string(col.Name)
+		e.uint32(col.Type)
+		e.uint32(col.Mode)
+	}
+}
+
+// Encode 将消息编码为pgoutput协议字节，Parse的逆操作
+// Relation/Type/Insert/Update/Delete/Truncate/LogicalMessage的XID非0时按流式事务编码，需用ParseStream解析
+func Encode(msg Message) ([]byte, error) {
+	e := &encoder{}
+	switch v := msg.(type) {
+	case Begin:
+		e.uint8('B')
+		e.uint64(v.LSN)
+		e.timestamp(v.Timestamp)
+		e.uint32(uint32(v.XID))
+	case Commit:
+		e.uint8('C')
+		e.uint8(v.Flags)
+		e.uint64(v.LSN)
+		e.uint64(v.TransactionLSN)
+		e.timestamp(v.Timestamp)
+	case Origin:
+		e.uint8('O')
+		e.uint64(v.LSN)
+		e.string(v.Name)
+	case Relation:
+		e.uint8('R')
+		e.xid(v.XID)
+		e.uint32(v.ID)
+		e.string(v.Namespace)
+		e.string(v.Name)
+		e.uint8(v.Replica)
+		e.columns(v.Columns)
+	case Type:
+		e.uint8('Y')
+		e.xid(v.XID)
+		e.uint32(v.ID)
+		e.string(v.Namespace)
+		e.string(v.Name)
+	case Insert:
+		e.uint8('I')
+		e.xid(v.XID)
+		e.uint32(v.RelationID)
+		e.new(v.New)
+		if err := e.tupledata(v.Row); err != nil {
+			return nil, err
+		}
+	case Update:
+		e.uint8('U')
+		e.xid(v.XID)
+		e.uint32(v.RelationID)
+		if v.Key || v.Old {
+			if v.Key {
+				e.uint8('K')
+			} else {
+				e.uint8('O')
+			}
+			if err := e.tupledata(v.OldRow); err != nil {
+				return nil, err
+			}
+		}
+		e.new(v.New)
+		if err := e.tupledata(v.Row); err != nil {
+			return nil, err
+		}
+	case Delete:
+		e.uint8('D')
+		e.xid(v.XID)
+		e.uint32(v.RelationID)
+		if v.Key {
+			e.uint8('K')
+		} else if v.Old {
+			e.uint8('O')
+		}
+		if err := e.tupledata(v.Row); err != nil {
+			return nil, err
+		}
+	case Truncate:
+		e.uint8('T')
+		e.xid(v.XID)
+		e.uint32(uint32(len(v.RelationIDs)))
+		e.uint8(v.Options)
+		for _, id := range v.RelationIDs {
+			e.uint32(id)
+		}
+	case LogicalMessage:
+		e.uint8('M')
+		e.xid(v.XID)
+		e.bool(v.Transactional)
+		e.uint64(v.LSN)
+		e.string(v.Prefix)
+		e.bytes(v.Content)
+	case StreamStart:
+		e.uint8('S')
+		e.uint32(v.XID)
+		e.bool(v.FirstSegment)
+	case StreamStop:
+		e.uint8('E')
+	case StreamCommit:
+		e.uint8('c')
+		e.uint32(v.XID)
+		e.uint8(v.Flags)
+		e.uint64(v.LSN)
+		e.uint64(v.TransactionLSN)
+		e.timestamp(v.Timestamp)
+	case StreamAbort:
+		e.uint8('A')
+		e.uint32(v.XID)
+		e.uint32(v.SubXID)
+	case BeginPrepare:
+		e.uint8('b')
+		e.uint64(v.LSN)
+		e.uint64(v.EndLSN)
+		e.timestamp(v.Timestamp)
+		e.uint32(v.XID)
+		e.string(v.GID)
+	case Prepare:
+		e.uint8('P')
+		e.prepare(v.Flags, v.LSN, v.EndLSN, v.Timestamp, v.XID, v.GID)
+	case CommitPrepared:
+		e.uint8('K')
+		e.prepare(v.Flags, v.LSN, v.EndLSN, v.Timestamp, v.XID, v.GID)
+	case RollbackPrepared:
+		e.uint8('r')
+		e.uint8(v.Flags)
+		e.uint64(v.PrepareEndLSN)
+		e.uint64(v.EndLSN)
+		e.timestamp(v.PrepareTimestamp)
+		e.timestamp(v.Timestamp)
+		e.uint32(v.XID)
+		e.string(v.GID)
+	case StreamPrepare:
+		e.uint8('p')
+		e.prepare(v.Flags, v.LSN, v.EndLSN, v.Timestamp, v.XID, v.GID)
+	default:
+		return nil, fmt.Errorf("unknown message %T", msg)
+	}
+	return e.buf, nil
+}
+
+// Prepare/CommitPrepared/StreamPrepare共用的字段
+func (e *encoder) prepare(flags uint8, lsn, endLSN uint64, ts time.Time, xid uint32, gid string) {
+	e.uint8(flags)
+	e.uint64(lsn)
+	e.uint64(endLSN)
+	e.timestamp(ts)
+	e.uint32(xid)
+	e.string(gid)
+}
@@ -0,0 +1,53 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEncodeRoundTrip(t *testing.T) {
+	messages, streamed := parseSeeds()
+	// 零值时间与非新元组
+	messages = append(messages,
+		Begin{LSN: 1, XID: 1},
+		Commit{LSN: 1, TransactionLSN: 2},
+		RollbackPrepared{XID: 1, GID: "gid"},
+		Insert{RelationID: 1, Row: []Tuple{}},
+		Update{RelationID: 1, Row: []Tuple{}},
+		Begin{LSN: 1, Timestamp: time.Date(1999, 12, 31, 23, 59, 59, 999999000, time.UTC), XID: 1},
+	)
+	tests := []struct {
+		parse    func([]byte) (Message, error)
+		messages []Message
+	}{
+		{Parse, messages},
+		{ParseStream, streamed},
+	}
+	for _, tt := range tests {
+		for _, msg := range tt.messages {
+			src, err := Encode(msg)
+			if err != nil {
+				t.Errorf("encode %#v: %s", msg, err)
+				continue
+			}
+			got, err := tt.parse(src)
+			if err != nil {
+				t.Errorf("parse %#v: %s", msg, err)
+				continue
+			}
+			if !reflect.DeepEqual(got, msg) {
+				t.Errorf("round trip:\n got %#v\nwant %#v", got, msg)
+			}
+		}
+	}
+}
+
+func TestEncodeInvalid(t *testing.T) {
+	if _, err := Encode(Insert{Row: []Tuple{{Flag: 'x'}}}); err == nil {
+		t.Error("expected error for unknown tuple flag")
+	}
+	if _, err := Encode(nil); err == nil {
+		t.Error("expected error for nil message")
+	}
+}
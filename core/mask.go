@@ -0,0 +1,175 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/pgtype"
+)
+
+// MaskAction 列脱敏方式
+type MaskAction int
+
+const (
+	MaskDrop     MaskAction = iota + 1 //删除列
+	MaskHash                           //加盐SHA-256，十六进制
+	MaskTruncate                       //保留前Length个字符，Length为0时清空为""
+	MaskRedact                         //替换为Replacement
+	MaskTokenize                       //替换为令牌，同一值令牌相同
+)
+
+// MaskRule 列投影与脱敏规则，NULL值保持nil
+type MaskRule struct {
+	Action      MaskAction
+	Salt        string                             //MaskHash的盐，MaskTokenize默认令牌的HMAC密钥
+	Length      int                                //MaskTruncate保留的字符数，不能为负数
+	Replacement string                             //MaskRedact的替换文本，默认"***"
+	Tokenizer   func(value string) (string, error) //MaskTokenize的令牌生成，默认"tok_"+HMAC-SHA256前32位十六进制
+}
+
+const defaultRedaction = "***"
+
+// Mask 为列("schema.table.column")设置投影与脱敏规则
+// 规则在推送给handler之前作用于Body、OldBody、Columns、Changes及Unchanged，对所有输出插件(含自定义插件)生效
+// 列名或规则无效(如未设置Action)时退出
+func (t *Replication) Mask(column string, rule MaskRule) *Replication {
+	if strings.Count(column, ".") < 2 {
+		log.Fatalf("mask %s: column must be schema.table.column", column)
+	}
+	if err := rule.validate(); err != nil {
+		log.Fatalf("mask %s: %s", column, err)
+	}
+	if t.masks == nil {
+		t.masks = make(map[string]MaskRule)
+	}
+	t.masks[column] = rule
+	return t
+}
+
+// 按规则处理消息中的列
+func (t *Replication) mask(m *ReplicationMessage) (err error) {
+	if len(t.masks) == 0 || m.TableName == "" {
+		return nil
+	}
+	prefix := m.SchemaName + "." + m.TableName + "."
+	rules := make(map[string]MaskRule)
+	for _, row := range []map[string]interface{}{m.Body, m.OldBody} {
+		for name := range row {
+			if rule, ok := t.masks[prefix+name]; ok {
+				rules[name] = rule
+			}
+		}
+	}
+	for _, name := range m.Unchanged {
+		if rule, ok := t.masks[prefix+name]; ok {
+			rules[name] = rule
+		}
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	for name, rule := range rules {
+		if rule.Action == MaskDrop {
+			delete(m.Body, name)
+			delete(m.OldBody, name)
+			delete(m.Changes, name)
+			m.Columns = removeString(m.Columns, name)
+			m.Unchanged = removeString(m.Unchanged, name)
+			continue
+		}
+		for _, row := range []map[string]interface{}{m.Body, m.OldBody} {
+			if value, ok := row[name]; ok {
+				if row[name], err = rule.apply(value); err != nil {
+					return fmt.Errorf("mask %s%s: %s", prefix, name, err)
+				}
+			}
+		}
+		if change, ok := m.Changes[name]; ok {
+			if change.Old, err = rule.apply(change.Old); err != nil {
+				return fmt.Errorf("mask %s%s: %s", prefix, name, err)
+			}
+			if change.New, err = rule.apply(change.New); err != nil {
+				return fmt.Errorf("mask %s%s: %s", prefix, name, err)
+			}
+			m.Changes[name] = change
+		}
+	}
+	return nil
+}
+
+// 校验规则
+func (rule MaskRule) validate() error {
+	if rule.Action < MaskDrop || rule.Action > MaskTokenize {
+		return fmt.Errorf("unknown mask action %d", rule.Action)
+	}
+	if rule.Action == MaskTruncate && rule.Length < 0 {
+		return fmt.Errorf("negative truncate length %d", rule.Length)
+	}
+	return nil
+}
+
+func (rule MaskRule) apply(value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	text := maskText(value)
+	switch rule.Action {
+	case MaskHash:
+		sum := sha256.Sum256([]byte(rule.Salt + text))
+		return hex.EncodeToString(sum[:]), nil
+	case MaskTruncate:
+		runes := []rune(text)
+		if rule.Length >= 0 && len(runes) > rule.Length {
+			runes = runes[:rule.Length]
+		}
+		return string(runes), nil
+	case MaskRedact:
+		if rule.Replacement == "" {
+			return defaultRedaction, nil
+		}
+		return rule.Replacement, nil
+	case MaskTokenize:
+		if rule.Tokenizer != nil {
+			return rule.Tokenizer(text)
+		}
+		mac := hmac.New(sha256.New, []byte(rule.Salt))
+		mac.Write([]byte(text))
+		return "tok_" + hex.EncodeToString(mac.Sum(nil))[:32], nil
+	}
+	return nil, fmt.Errorf("unknown mask action %d", rule.Action)
+}
+
+// 列值的文本形式
+func maskText(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case pgtype.TextEncoder:
+		if buf, err := v.EncodeText(nil, nil); err == nil {
+			return string(buf)
+		}
+	}
+	return fmt.Sprint(value)
+}
+
+func removeString(list []string, s string) []string {
+	res := list[:0]
+	for _, item := range list {
+		if item != s {
+			res = append(res, item)
+		}
+	}
+	if len(res) == 0 {
+		return nil
+	}
+	return res
+}
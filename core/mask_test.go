@@ -0,0 +1,113 @@
+package core
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx"
+)
+
+func TestMaskRuleValidate(t *testing.T) {
+	tests := []struct {
+		rule MaskRule
+		ok   bool
+	}{
+		{MaskRule{}, false},
+		{MaskRule{Action: MaskTokenize + 1}, false},
+		{MaskRule{Action: -1}, false},
+		{MaskRule{Action: MaskTruncate, Length: -1}, false},
+		{MaskRule{Action: MaskTruncate}, true},
+		{MaskRule{Action: MaskDrop}, true},
+		{MaskRule{Action: MaskHash, Salt: "s"}, true},
+		{MaskRule{Action: MaskRedact}, true},
+		{MaskRule{Action: MaskTokenize}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.rule.validate(); (err == nil) != tt.ok {
+			t.Errorf("%+v: error %v, want ok %v", tt.rule, err, tt.ok)
+		}
+	}
+}
+
+func TestMaskApply(t *testing.T) {
+	r := NewReplication("test_slot", pgx.ConnConfig{}).
+		Mask("public.users.password", MaskRule{Action: MaskDrop}).
+		Mask("public.users.name", MaskRule{Action: MaskTruncate, Length: 2}).
+		Mask("public.users.phone", MaskRule{Action: MaskTruncate}).
+		Mask("public.users.email", MaskRule{Action: MaskRedact})
+	m := ReplicationMessage{SchemaName: "public", TableName: "users", Body: map[string]interface{}{
+		"password": "secret", "name": "张三丰", "phone": "13800000000", "email": "a@b.c", "note": nil,
+	}}
+	if err := r.mask(&m); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"name": "张三", "phone": "", "email": "***", "note": nil}
+	if len(m.Body) != len(want) {
+		t.Fatalf("got %v, want %v", m.Body, want)
+	}
+	for name, value := range want {
+		if m.Body[name] != value {
+			t.Errorf("%s: got %#v, want %#v", name, m.Body[name], value)
+		}
+	}
+}
+
+// 按wal位置返回固定消息的自定义插件
+type staticPlugin struct {
+	msgs []ReplicationMessage
+}
+
+func (p *staticPlugin) Name() string                        { return "static" }
+func (p *staticPlugin) SlotOptions(t *Replication) []string { return nil }
+func (p *staticPlugin) StartArgs(t *Replication) []string   { return nil }
+func (p *staticPlugin) Reset()                              {}
+func (p *staticPlugin) Decode(t *Replication, message *pgx.WalMessage) ([]ReplicationMessage, error) {
+	return p.msgs, nil
+}
+
+// 脱敏对自定义插件同样生效
+func TestMaskCustomPlugin(t *testing.T) {
+	r, _ := testReplication()
+	tx := &Transaction{Xid: 1, CommitLsn: 1}
+	r.Plugin(&staticPlugin{msgs: []ReplicationMessage{
+		{EventType: EventType_UPDATE, SchemaName: "public", TableName: "users", Tx: tx,
+			Body:      map[string]interface{}{"id": int64(1), "email": "new@b.c", "password": "new"},
+			OldBody:   map[string]interface{}{"id": int64(1), "email": "old@b.c", "password": "old"},
+			Columns:   []string{"email", "password"},
+			Changes:   map[string]ColumnChange{"email": {Old: "old@b.c", New: "new@b.c"}, "password": {Old: "old", New: "new"}},
+			Unchanged: []string{"password"}},
+		{EventType: EventType_COMMIT, Lsn: 1, Tx: tx},
+	}}).
+		Mask("public.users.password", MaskRule{Action: MaskDrop}).
+		Mask("public.users.email", MaskRule{Action: MaskRedact})
+	var got []ReplicationMessage
+	err := r.handle(&pgx.WalMessage{WalStart: 1}, func(msgs ...ReplicationMessage) DMLHandlerStatus {
+		got = msgs
+		return DMLHandlerStatusSuccess
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d messages, want 2", len(got))
+	}
+	m := got[0]
+	if _, ok := m.Body["password"]; ok || m.Body["email"] != "***" || m.OldBody["email"] != "***" {
+		t.Errorf("body %v, old body %v", m.Body, m.OldBody)
+	}
+	if len(m.Columns) != 1 || m.Columns[0] != "email" || len(m.Changes) != 1 || m.Changes["email"].New != "***" || len(m.Unchanged) != 0 {
+		t.Errorf("columns %v, changes %v, unchanged %v", m.Columns, m.Changes, m.Unchanged)
+	}
+	// 脱敏失败不重连
+	r.Plugin(&staticPlugin{msgs: []ReplicationMessage{
+		{EventType: EventType_INSERT, SchemaName: "public", TableName: "users", Body: map[string]interface{}{"token": "x"}},
+	}}).Mask("public.users.token", MaskRule{Action: MaskTokenize, Tokenizer: func(string) (string, error) {
+		return "", errors.New("tokenizer unavailable")
+	}})
+	err = r.handle(&pgx.WalMessage{WalStart: 2}, func(msgs ...ReplicationMessage) DMLHandlerStatus {
+		return DMLHandlerStatusSuccess
+	})
+	if !errors.As(err, &handleError{}) {
+		t.Errorf("got %v, want handleError", err)
+	}
+}
@@ -0,0 +1,215 @@
+package core
+
+import (
+	"encoding/base64"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/pgtype"
+)
+
+// Normalize 将解码后的列值转换为可稳定序列化为JSON的值
+// 按OID(类型)的映射：
+//
+//	bool                               bool
+//	int2/int4/int8/oid/xid/cid          int64
+//	money                               int64，最小货币单位(如分)，见Money
+//	float4/float8                       float64，NaN/±Infinity为字符串"NaN"/"Infinity"/"-Infinity"
+//	numeric                             十进制字符串，如"12.50"，NaN/±Infinity为"NaN"/"Infinity"/"-Infinity"
+//	text/varchar/bpchar/name/enum/未知   string
+//	bytea                               base64字符串
+//	date                                "2006-01-02"，infinity为"infinity"/"-infinity"
+//	timestamp/timestamptz               UTC的RFC3339Nano字符串，infinity为"infinity"/"-infinity"
+//	json/jsonb                          解析后的JSON值
+//	数组                                 JSON数组(多维为嵌套数组)，元素按上述规则转换
+//	inet/cidr/macaddr/uuid/interval/bit/varbit/time/range/几何类型等
+//	                                    PostgreSQL文本格式字符串
+//
+// NULL为nil，Converted返回转换器的结果
+func Normalize(value pgtype.Value) interface{} {
+	switch v := value.(type) {
+	case nil, Null:
+		return nil
+	case Converted:
+		return v.Result
+	}
+	if value.Get() == nil {
+		return nil
+	}
+	switch v := value.(type) {
+	case *pgtype.Bool:
+		return v.Bool
+	case *pgtype.Int2:
+		return int64(v.Int)
+	case *pgtype.Int4:
+		return int64(v.Int)
+	case *pgtype.Int8:
+		return v.Int
+	case *Money:
+		return v.Int
+	case *pgtype.OIDValue:
+		return int64(v.Uint)
+	case *pgtype.XID:
+		return int64(v.Uint)
+	case *pgtype.CID:
+		return int64(v.Uint)
+	case *pgtype.Float4:
+		return normalizeFloat(float64(v.Float))
+	case *pgtype.Float8:
+		return normalizeFloat(v.Float)
+	case *pgtype.Numeric:
+		return numericString(v.Int, v.Exp)
+	case *pgtype.Numrange:
+		return numrangeString(v)
+	case *pgtype.Bytea:
+		return base64.StdEncoding.EncodeToString(v.Bytes)
+	case *pgtype.Date:
+		if s, ok := infinity(v.InfinityModifier); ok {
+			return s
+		}
+		return v.Time.Format("2006-01-02")
+	case *pgtype.Timestamp:
+		if s, ok := infinity(v.InfinityModifier); ok {
+			return s
+		}
+		return v.Time.UTC().Format(time.RFC3339Nano)
+	case *pgtype.Timestamptz:
+		if s, ok := infinity(v.InfinityModifier); ok {
+			return s
+		}
+		return v.Time.UTC().Format(time.RFC3339Nano)
+	case *pgtype.JSON, *pgtype.JSONB:
+		return value.Get()
+	}
+	if elements, dimensions, ok := arrayValue(value); ok {
+		return normalizeArray(elements, dimensions)
+	}
+	if encoder, ok := value.(pgtype.TextEncoder); ok {
+		if buf, err := encoder.EncodeText(nil, nil); err == nil {
+			return string(buf)
+		}
+	}
+	switch v := value.Get().(type) {
+	case string:
+		return v
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	}
+	return value.Get()
+}
+
+func normalizeFloat(f float64) interface{} {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	}
+	return f
+}
+
+func infinity(modifier pgtype.InfinityModifier) (string, bool) {
+	switch modifier {
+	case pgtype.Infinity:
+		return "infinity", true
+	case pgtype.NegativeInfinity:
+		return "-infinity", true
+	}
+	return "", false
+}
+
+// numeric的十进制表示，值为 i * 10^exp
+func numericString(i *big.Int, exp int32) string {
+	if i == nil {
+		return "0"
+	}
+	digits := i.String()
+	sign := ""
+	if strings.HasPrefix(digits, "-") {
+		sign, digits = "-", digits[1:]
+	}
+	if exp >= 0 {
+		if i.Sign() == 0 {
+			return "0"
+		}
+		return sign + digits + strings.Repeat("0", int(exp))
+	}
+	scale := int(-exp)
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+}
+
+// numrange的文本格式，边界为十进制(pgtype输出为科学计数法)
+func numrangeString(v *pgtype.Numrange) string {
+	if v.LowerType == pgtype.Empty {
+		return "empty"
+	}
+	var b strings.Builder
+	if v.LowerType == pgtype.Inclusive {
+		b.WriteByte('[')
+	} else {
+		b.WriteByte('(')
+	}
+	if v.LowerType != pgtype.Unbounded {
+		b.WriteString(numericString(v.Lower.Int, v.Lower.Exp))
+	}
+	b.WriteByte(',')
+	if v.UpperType != pgtype.Unbounded {
+		b.WriteString(numericString(v.Upper.Int, v.Upper.Exp))
+	}
+	if v.UpperType == pgtype.Inclusive {
+		b.WriteByte(']')
+	} else {
+		b.WriteByte(')')
+	}
+	return b.String()
+}
+
+// pgtype数组类型均包含Elements与Dimensions字段
+func arrayValue(value pgtype.Value) (elements reflect.Value, dimensions []pgtype.ArrayDimension, ok bool) {
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return
+	}
+	rv = rv.Elem()
+	elements = rv.FieldByName("Elements")
+	dims := rv.FieldByName("Dimensions")
+	if !elements.IsValid() || elements.Kind() != reflect.Slice || !dims.IsValid() {
+		return
+	}
+	dimensions, ok = dims.Interface().([]pgtype.ArrayDimension)
+	return
+}
+
+// 按维度转换为嵌套数组
+func normalizeArray(elements reflect.Value, dimensions []pgtype.ArrayDimension) []interface{} {
+	if len(dimensions) == 0 {
+		return []interface{}{}
+	}
+	index := 0
+	var build func(dim int) []interface{}
+	build = func(dim int) []interface{} {
+		res := make([]interface{}, 0, dimensions[dim].Length)
+		for n := int32(0); n < dimensions[dim].Length; n++ {
+			if dim < len(dimensions)-1 {
+				res = append(res, build(dim+1))
+				continue
+			}
+			if index >= elements.Len() {
+				break
+			}
+			element, _ := elements.Index(index).Addr().Interface().(pgtype.Value)
+			index++
+			res = append(res, Normalize(element))
+		}
+		return res
+	}
+	return build(0)
+}
@@ -1,24 +1,95 @@
 package core
 
+import "time"
+
 type EventType int
 
 const (
-	EventType_READY    EventType = 0
-	EventType_INSERT   EventType = 1
-	EventType_UPDATE   EventType = 2
-	EventType_DELETE   EventType = 3
-	EventType_TRUNCATE EventType = 4
-	EventType_COMMIT   EventType = 10
+	EventType_READY         EventType = 0
+	EventType_INSERT        EventType = 1
+	EventType_UPDATE        EventType = 2
+	EventType_DELETE        EventType = 3
+	EventType_TRUNCATE      EventType = 4
+	EventType_MESSAGE       EventType = 5 //pg_logical_emit_message()消息，见Prefix/Content
+	EventType_SCHEMA_CHANGE EventType = 6 //表结构变更(ALTER TABLE后首次变更前单独推送，不随所属事务丢弃或回滚)，见Schema
+	EventType_COMMIT        EventType = 10
+	// 流式事务(protocol v2)
+	EventType_STREAM_STOP   EventType = 11 //流式事务块结束，此前同批消息均属于未提交事务Xid
+	EventType_STREAM_COMMIT EventType = 12 //流式事务Xid已提交
+	EventType_STREAM_ABORT  EventType = 13 //流式事务Xid(或其子事务SubXid)已回滚，需丢弃对应变更
+	// 两阶段事务(protocol v3)
+	EventType_PREPARE           EventType = 14 //PREPARE TRANSACTION，此前同批消息均属于该Gid事务
+	EventType_COMMIT_PREPARED   EventType = 15 //COMMIT PREPARED Gid
+	EventType_ROLLBACK_PREPARED EventType = 16 //ROLLBACK PREPARED Gid，需丢弃对应Gid的变更
+	EventType_STREAM_PREPARE    EventType = 17 //流式事务Xid PREPARE TRANSACTION
 )
 
+// Transaction 事务信息，同一事务内的消息共享
+type Transaction struct {
+	Xid        uint32
+	BeginLsn   uint64    //Begin消息的wal位置
+	CommitLsn  uint64    //commit(或prepare)记录的lsn
+	EndLsn     uint64    //事务结束lsn，流式/两阶段事务提交前为0
+	CommitTime time.Time //提交(或prepare)时间
+	Origin     string    //复制源名称，本地写入时为空
+	OriginLsn  uint64    //复制源上的commit lsn
+}
+
+// ColumnRetype 列类型变更
+type ColumnRetype struct {
+	Name    string
+	OldType uint32
+	OldMode uint32 //类型修饰(atttypmod)
+	Type    uint32
+	Mode    uint32
+}
+
+// ColumnChange UPDATE列的新旧值
+type ColumnChange struct {
+	Old interface{}
+	New interface{}
+}
+
+// SchemaChange 表结构变更，列重命名表现为删除+新增
+type SchemaChange struct {
+	OldSchemaName string //表重命名或更换schema时有值
+	OldTableName  string
+	Added         []Column
+	Dropped       []Column
+	Retyped       []ColumnRetype
+	OldKeys       []string //复制标识(主键)列
+	Keys          []string
+	KeyChanged    bool
+	OldReplica    uint8 //复制标识：d默认/n无/f全部列/i索引
+	Replica       uint8
+}
+
 type ReplicationMessage struct {
 	Lsn        uint64
+	Xid        uint32 //事务xid
+	SubXid     uint32 //流式事务中变更所属(子)事务xid
+	Tx         *Transaction
+	Gid        string //两阶段事务gid，仅two-phase事件有值
 	RelationID uint32
 	EventType  EventType
 	SchemaName string
 	TableName  string
 	Body       map[string]interface{}
 	Columns    []string
+	Unchanged  []string                //未变更且无法从旧行补全的TOAST列，不出现在Body中(区别于被更新为NULL)
+	OldBody    map[string]interface{}  //UPDATE/DELETE的旧行，UPDATE仅在主键变更或REPLICA IDENTITY FULL时有值
+	OldKeyOnly bool                    //OldBody仅包含复制标识(主键)列，否则为整行；DELETE时Body同样仅含这些列
+	Schema     *SchemaChange           //表结构变更，仅EventType_SCHEMA_CHANGE有值
+	Changes    map[string]ColumnChange //UPDATE变更列的新旧值，与Columns对应，需有旧行(OldBody)
+
+	// TRUNCATE选项，仅EventType_TRUNCATE有值
+	Cascade         bool
+	RestartIdentity bool
+
+	// 逻辑解码消息，仅EventType_MESSAGE有值
+	Transactional bool
+	Prefix        string
+	Content       []byte
 }
 
 type DMLHandlerStatus int
@@ -9,45 +9,89 @@ import (
 	"github.com/jackc/pgx/pgtype"
 )
 
+// decoder 按pgoutput协议读取消息字段
+// 读取越界或格式错误时记录首个错误(含消息类型与字节偏移)，此后所有读取均返回零值
 type decoder struct {
-	order binary.ByteOrder
-	buf   *bytes.Buffer
+	order   binary.ByteOrder
+	buf     *bytes.Buffer
+	msgType byte
+	size    int
+	err     error
+}
+
+func newDecoder(src []byte) *decoder {
+	return &decoder{order: binary.BigEndian, buf: bytes.NewBuffer(src[1:]), msgType: src[0], size: len(src)}
+}
+
+// 当前读取位置(相对于消息起始，含类型字节)
+func (d *decoder) offset() int {
+	return d.size - d.buf.Len()
+}
+
+func (d *decoder) fail(format string, args ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("message %q at offset %d: %s", d.msgType, d.offset(), fmt.Sprintf(format, args...))
+	}
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > d.buf.Len() {
+		d.fail("need %d bytes, %d left", n, d.buf.Len())
+		return nil
+	}
+	return d.buf.Next(n)
 }
 
 func (d *decoder) bool() bool {
-	x := d.buf.Next(1)[0]
-	return x != 0
+	return d.uint8() != 0
 }
 
 func (d *decoder) uint8() uint8 {
-	x := d.buf.Next(1)[0]
-	return x
-
+	b := d.next(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
 }
 
 func (d *decoder) uint16() uint16 {
-	x := d.order.Uint16(d.buf.Next(2))
-	return x
+	b := d.next(2)
+	if b == nil {
+		return 0
+	}
+	return d.order.Uint16(b)
 }
 
 func (d *decoder) string() string {
-	s, err := d.buf.ReadBytes(0)
-	if err != nil {
-		// TODO: Return an error
-		panic(err)
+	if d.err != nil {
+		return ""
 	}
-	return string(s[:len(s)-1])
+	i := bytes.IndexByte(d.buf.Bytes(), 0)
+	if i < 0 {
+		d.fail("unterminated string")
+		return ""
+	}
+	s := d.buf.Next(i + 1)
+	return string(s[:i])
 }
 
 func (d *decoder) uint32() uint32 {
-	x := d.order.Uint32(d.buf.Next(4))
-	return x
-
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+	return d.order.Uint32(b)
 }
 
 func (d *decoder) uint64() uint64 {
-	x := d.order.Uint64(d.buf.Next(8))
-	return x
+	b := d.next(8)
+	if b == nil {
+		return 0
+	}
+	return d.order.Uint64(b)
 }
 
 func (d *decoder) int8() int8   { return int8(d.uint8()) }
@@ -55,31 +99,62 @@ func (d *decoder) int16() int16 { return int16(d.uint16()) }
 func (d *decoder) int32() int32 { return int32(d.uint32()) }
 func (d *decoder) int64() int64 { return int64(d.uint64()) }
 
+// PostgreSQL epoch(2000-01-01)的Unix秒数
+const pgEpochUnix = 946684800
+
+// 自PostgreSQL epoch的微秒数，按秒计算避免time.Duration溢出
 func (d *decoder) timestamp() time.Time {
-	micro := int(d.uint64())
-	ts := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
-	return ts.Add(time.Duration(micro) * time.Microsecond)
+	micro := d.int64()
+	return time.Unix(pgEpochUnix+micro/1000000, micro%1000000*1000).UTC()
+}
+
+// 长度前缀(Int32)的字节串
+func (d *decoder) bytes() []byte {
+	size := d.uint32()
+	if d.err != nil {
+		return nil
+	}
+	if uint64(size) > uint64(d.buf.Len()) {
+		d.fail("need %d bytes, %d left", size, d.buf.Len())
+		return nil
+	}
+	return d.next(int(size))
 }
 
 func (d *decoder) rowinfo(char byte) bool {
-	if d.buf.Next(1)[0] == char {
-		return true
-	} else {
-		d.buf.UnreadByte()
+	if d.err != nil || d.buf.Len() == 0 {
 		return false
 	}
+	if d.buf.Bytes()[0] == char {
+		d.buf.Next(1)
+		return true
+	}
+	return false
 }
 
 func (d *decoder) tupledata() []Tuple {
 	size := int(d.uint16())
+	if d.err != nil {
+		return nil
+	}
 	data := make([]Tuple, size)
 	for i := 0; i < size; i++ {
-		switch d.buf.Next(1)[0] {
+		switch flag := d.uint8(); flag {
 		case 'n':
+			data[i] = Tuple{Flag: 'n'}
 		case 'u':
+			data[i] = Tuple{Flag: 'u'}
 		case 't':
-			vsize := int(d.order.Uint32(d.buf.Next(4)))
-			data[i] = Tuple{Flag: 't', Value: d.buf.Next(vsize)}
+			data[i] = Tuple{Flag: 't', Value: d.bytes()}
+		case 'b':
+			data[i] = Tuple{Flag: 'b', Value: d.bytes()}
+		default:
+			if d.err == nil {
+				d.fail("unknown tuple data flag %q", flag)
+			}
+		}
+		if d.err != nil {
+			return nil
 		}
 	}
 	return data
@@ -87,6 +162,9 @@ func (d *decoder) tupledata() []Tuple {
 
 func (d *decoder) columns() []Column {
 	size := int(d.uint16())
+	if d.err != nil {
+		return nil
+	}
 	data := make([]Column, size)
 	for i := 0; i < size; i++ {
 		data[i] = Column{
@@ -95,6 +173,9 @@ func (d *decoder) columns() []Column {
 			Type: d.uint32(),
 			Mode: d.uint32(),
 		}
+		if d.err != nil {
+			return nil
+		}
 	}
 	return data
 }
@@ -119,6 +200,8 @@ type Commit struct {
 }
 
 type Relation struct {
+	// Xid of the transaction (only present for streamed transactions).
+	XID uint32
 	// ID of the relation.
 	ID uint32
 	// Namespace (empty string for pg_catalog).
@@ -129,6 +212,8 @@ type Relation struct {
 }
 
 type Type struct {
+	// Xid of the transaction (only present for streamed transactions).
+	XID uint32
 	// ID of the data type
 	ID        uint32
 	Namespace string
@@ -136,6 +221,8 @@ type Type struct {
 }
 
 type Insert struct {
+	// Xid of the transaction (only present for streamed transactions).
+	XID uint32
 	/// ID of the relation corresponding to the ID in the relation message.
 	RelationID uint32
 	// Identifies the following TupleData message as a new tuple.
@@ -144,6 +231,8 @@ type Insert struct {
 }
 
 type Update struct {
+	// Xid of the transaction (only present for streamed transactions).
+	XID uint32
 	/// ID of the relation corresponding to the ID in the relation message.
 	RelationID uint32
 	// Identifies the following TupleData message as a new tuple.
@@ -155,6 +244,8 @@ type Update struct {
 }
 
 type Delete struct {
+	// Xid of the transaction (only present for streamed transactions).
+	XID uint32
 	/// ID of the relation corresponding to the ID in the relation message.
 	RelationID uint32
 	// Identifies the following TupleData message as a new tuple.
@@ -163,9 +254,144 @@ type Delete struct {
 	Row []Tuple
 }
 
+// TRUNCATE选项位
+const (
+	TruncateCascade         uint8 = 1
+	TruncateRestartIdentity uint8 = 2
+)
+
 type Truncate struct {
-	/// ID of the relation corresponding to the ID in the relation message.
-	RelationID uint32
+	// Xid of the transaction (only present for streamed transactions).
+	XID uint32
+	// Option bits for TRUNCATE: 1 for CASCADE, 2 for RESTART IDENTITY.
+	Options uint8
+	/// IDs of the relations corresponding to the ID in the relation message.
+	RelationIDs []uint32
+}
+
+func (t Truncate) Cascade() bool         { return t.Options&TruncateCascade != 0 }
+func (t Truncate) RestartIdentity() bool { return t.Options&TruncateRestartIdentity != 0 }
+
+// LogicalMessage pg_logical_emit_message()写入的逻辑解码消息
+type LogicalMessage struct {
+	// Xid of the transaction (only present for streamed transactions).
+	XID uint32
+	// Whether the message is transactional.
+	Transactional bool
+	// The LSN of the logical decoding message.
+	LSN uint64
+	// The prefix of the logical decoding message.
+	Prefix string
+	// The content of the logical decoding message.
+	Content []byte
+}
+
+// StreamStart 流式事务块开始(protocol v2)
+type StreamStart struct {
+	// Xid of the transaction.
+	XID uint32
+	// The first stream segment of the transaction.
+	FirstSegment bool
+}
+
+// StreamStop 流式事务块结束(protocol v2)
+type StreamStop struct{}
+
+// StreamCommit 流式事务提交(protocol v2)
+type StreamCommit struct {
+	// Xid of the transaction.
+	XID   uint32
+	Flags uint8
+	// The LSN of the commit.
+	LSN uint64
+	// The end LSN of the transaction.
+	TransactionLSN uint64
+	Timestamp      time.Time
+}
+
+// StreamAbort 流式事务回滚(protocol v2)
+// XID == SubXID 时整个事务回滚，否则仅回滚子事务
+type StreamAbort struct {
+	// Xid of the transaction.
+	XID uint32
+	// Xid of the subtransaction (will be same as xid of the transaction for top-level transactions).
+	SubXID uint32
+}
+
+// BeginPrepare 两阶段事务开始(protocol v3)
+type BeginPrepare struct {
+	// The LSN of the prepare.
+	LSN uint64
+	// The end LSN of the prepared transaction.
+	EndLSN uint64
+	// Prepare timestamp of the transaction.
+	Timestamp time.Time
+	// Xid of the transaction.
+	XID uint32
+	// The user defined GID of the two-phase transaction.
+	GID string
+}
+
+// Prepare 两阶段事务PREPARE TRANSACTION(protocol v3)
+type Prepare struct {
+	Flags uint8
+	// The LSN of the prepare.
+	LSN uint64
+	// The end LSN of the prepared transaction.
+	EndLSN uint64
+	// Prepare timestamp of the transaction.
+	Timestamp time.Time
+	// Xid of the transaction.
+	XID uint32
+	// The user defined GID of the two-phase transaction.
+	GID string
+}
+
+// CommitPrepared 两阶段事务COMMIT PREPARED(protocol v3)
+type CommitPrepared struct {
+	Flags uint8
+	// The LSN of the commit prepared.
+	LSN uint64
+	// The end LSN of the commit prepared transaction.
+	EndLSN uint64
+	// Commit timestamp of the transaction.
+	Timestamp time.Time
+	// Xid of the transaction.
+	XID uint32
+	// The user defined GID of the two-phase transaction.
+	GID string
+}
+
+// RollbackPrepared 两阶段事务ROLLBACK PREPARED(protocol v3)
+type RollbackPrepared struct {
+	Flags uint8
+	// The end LSN of the prepared transaction.
+	PrepareEndLSN uint64
+	// The end LSN of the rollback prepared transaction.
+	EndLSN uint64
+	// Prepare timestamp of the transaction.
+	PrepareTimestamp time.Time
+	// Rollback timestamp of the transaction.
+	Timestamp time.Time
+	// Xid of the transaction.
+	XID uint32
+	// The user defined GID of the two-phase transaction.
+	GID string
+}
+
+// StreamPrepare 流式两阶段事务PREPARE TRANSACTION(protocol v3)
+type StreamPrepare struct {
+	Flags uint8
+	// The LSN of the prepare.
+	LSN uint64
+	// The end LSN of the prepared transaction.
+	EndLSN uint64
+	// Prepare timestamp of the transaction.
+	Timestamp time.Time
+	// Xid of the transaction.
+	XID uint32
+	// The user defined GID of the two-phase transaction.
+	GID string
 }
 
 type Origin struct {
@@ -204,11 +430,55 @@ func (Origin) msg()   {}
 func (Truncate) msg() {}
 func (Type) msg()     {}
 
+func (LogicalMessage) msg() {}
+
+func (StreamStart) msg()  {}
+func (StreamStop) msg()   {}
+func (StreamCommit) msg() {}
+func (StreamAbort) msg()  {}
+
+func (BeginPrepare) msg()     {}
+func (Prepare) msg()          {}
+func (CommitPrepared) msg()   {}
+func (RollbackPrepared) msg() {}
+func (StreamPrepare) msg()    {}
+
 // Parse a logical replication message.
 // See https://www.postgresql.org/docs/current/static/protocol-logicalrep-message-formats.html
 func Parse(src []byte) (Message, error) {
-	msgType := src[0]
-	d := &decoder{order: binary.BigEndian, buf: bytes.NewBuffer(src[1:])}
+	return parse(src, false)
+}
+
+// ParseStream 解析Stream Start与Stream Stop之间的消息
+// 此时Relation/Type/Insert/Update/Delete/Truncate/LogicalMessage均带有事务xid
+func ParseStream(src []byte) (Message, error) {
+	return parse(src, true)
+}
+
+func parse(src []byte, streamed bool) (Message, error) {
+	if len(src) == 0 {
+		return nil, fmt.Errorf("empty message")
+	}
+	d := newDecoder(src)
+	msg, err := d.message(streamed)
+	if err != nil {
+		return nil, err
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return msg, nil
+}
+
+func (d *decoder) message(streamed bool) (Message, error) {
+	msgType := d.msgType
+	var xid uint32
+	if streamed {
+		switch msgType {
+		case 'R', 'Y', 'I', 'U', 'D', 'T', 'M':
+			xid = d.uint32()
+		}
+	}
 	switch msgType {
 	case 'B':
 		b := Begin{}
@@ -229,7 +499,7 @@ func Parse(src []byte) (Message, error) {
 		o.Name = d.string()
 		return o, nil
 	case 'R':
-		r := Relation{}
+		r := Relation{XID: xid}
 		r.ID = d.uint32()
 		r.Namespace = d.string()
 		r.Name = d.string()
@@ -237,22 +507,22 @@ func Parse(src []byte) (Message, error) {
 		r.Columns = d.columns()
 		return r, nil
 	case 'Y':
-		t := Type{}
+		t := Type{XID: xid}
 		t.ID = d.uint32()
 		t.Namespace = d.string()
 		t.Name = d.string()
 		return t, nil
 	case 'I':
-		i := Insert{}
+		i := Insert{XID: xid}
 		i.RelationID = d.uint32()
 		i.New = d.uint8() > 0
 		i.Row = d.tupledata()
 		return i, nil
 	case 'U':
-		u := Update{}
+		u := Update{XID: xid}
 		u.RelationID = d.uint32()
 		u.Key = d.rowinfo('K')
-		u.Old = d.rowinfo('O')
+		u.Old = !u.Key && d.rowinfo('O')
 		if u.Key || u.Old {
 			u.OldRow = d.tupledata()
 		}
@@ -260,18 +530,97 @@ func Parse(src []byte) (Message, error) {
 		u.Row = d.tupledata()
 		return u, nil
 	case 'D':
-		dl := Delete{}
+		dl := Delete{XID: xid}
 		dl.RelationID = d.uint32()
 		dl.Key = d.rowinfo('K')
-		dl.Old = d.rowinfo('O')
+		dl.Old = !dl.Key && d.rowinfo('O')
 		dl.Row = d.tupledata()
 		return dl, nil
 	case 'T':
-		tr := Truncate{}
-		d.uint32()
-		d.int8()
-		tr.RelationID = d.uint32()
+		tr := Truncate{XID: xid}
+		size := d.uint32()
+		tr.Options = d.uint8()
+		if uint64(size)*4 > uint64(d.buf.Len()) {
+			d.fail("%d relations need %d bytes, %d left", size, uint64(size)*4, d.buf.Len())
+			return nil, d.err
+		}
+		tr.RelationIDs = make([]uint32, size)
+		for i := range tr.RelationIDs {
+			tr.RelationIDs[i] = d.uint32()
+		}
 		return tr, nil
+	case 'M':
+		lm := LogicalMessage{XID: xid}
+		lm.Transactional = d.uint8()&1 == 1
+		lm.LSN = d.uint64()
+		lm.Prefix = d.string()
+		lm.Content = d.bytes()
+		return lm, nil
+	case 'S':
+		ss := StreamStart{}
+		ss.XID = d.uint32()
+		ss.FirstSegment = d.uint8() == 1
+		return ss, nil
+	case 'E':
+		return StreamStop{}, nil
+	case 'c':
+		sc := StreamCommit{}
+		sc.XID = d.uint32()
+		sc.Flags = d.uint8()
+		sc.LSN = d.uint64()
+		sc.TransactionLSN = d.uint64()
+		sc.Timestamp = d.timestamp()
+		return sc, nil
+	case 'A':
+		sa := StreamAbort{}
+		sa.XID = d.uint32()
+		sa.SubXID = d.uint32()
+		return sa, nil
+	case 'b':
+		bp := BeginPrepare{}
+		bp.LSN = d.uint64()
+		bp.EndLSN = d.uint64()
+		bp.Timestamp = d.timestamp()
+		bp.XID = d.uint32()
+		bp.GID = d.string()
+		return bp, nil
+	case 'P':
+		p := Prepare{}
+		p.Flags = d.uint8()
+		p.LSN = d.uint64()
+		p.EndLSN = d.uint64()
+		p.Timestamp = d.timestamp()
+		p.XID = d.uint32()
+		p.GID = d.string()
+		return p, nil
+	case 'K':
+		cp := CommitPrepared{}
+		cp.Flags = d.uint8()
+		cp.LSN = d.uint64()
+		cp.EndLSN = d.uint64()
+		cp.Timestamp = d.timestamp()
+		cp.XID = d.uint32()
+		cp.GID = d.string()
+		return cp, nil
+	case 'r':
+		rp := RollbackPrepared{}
+		rp.Flags = d.uint8()
+		rp.PrepareEndLSN = d.uint64()
+		rp.EndLSN = d.uint64()
+		rp.PrepareTimestamp = d.timestamp()
+		rp.Timestamp = d.timestamp()
+		rp.XID = d.uint32()
+		rp.GID = d.string()
+		return rp, nil
+	case 'p':
+		sp := StreamPrepare{}
+		sp.Flags = d.uint8()
+		sp.LSN = d.uint64()
+		sp.EndLSN = d.uint64()
+		sp.Timestamp = d.timestamp()
+		sp.XID = d.uint32()
+		sp.GID = d.string()
+		return sp, nil
 	default:
 		return nil, fmt.Errorf("Unknown message type for %s (%d)", []byte{msgType}, msgType)
 	}
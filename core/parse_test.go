@@ -0,0 +1,130 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// 各类型的合法消息，用作解析测试与模糊测试的种子
+func parseSeeds() (messages []Message, streamed []Message) {
+	ts := time.Date(2023, 1, 2, 3, 4, 5, 123456000, time.UTC)
+	row := []Tuple{{Flag: 't', Value: []byte("1")}, {Flag: 'n'}, {Flag: 'u'}, {Flag: 'b', Value: []byte{0, 0, 0, 1}}}
+	columns := []Column{{Key: true, Name: "id", Type: 20, Mode: 0xffffffff}, {Name: "name", Type: 25}}
+	messages = []Message{
+		Begin{LSN: 100, Timestamp: ts, XID: 7},
+		Commit{LSN: 100, TransactionLSN: 120, Timestamp: ts},
+		Origin{LSN: 90, Name: "node1"},
+		Relation{ID: 16384, Namespace: "public", Name: "users", Replica: 'd', Columns: columns},
+		Type{ID: 16400, Namespace: "public", Name: "mood"},
+		Insert{RelationID: 16384, New: true, Row: row},
+		Update{RelationID: 16384, New: true, Row: row},
+		Update{RelationID: 16384, Key: true, OldRow: row[:1], New: true, Row: row},
+		Update{RelationID: 16384, Old: true, OldRow: row, New: true, Row: row},
+		Delete{RelationID: 16384, Key: true, Row: row[:1]},
+		Delete{RelationID: 16384, Old: true, Row: row},
+		Truncate{Options: TruncateCascade | TruncateRestartIdentity, RelationIDs: []uint32{16384, 16385}},
+		LogicalMessage{Transactional: true, LSN: 110, Prefix: "app", Content: []byte("hello")},
+		StreamStart{XID: 7, FirstSegment: true},
+		StreamStop{},
+		StreamCommit{XID: 7, LSN: 100, TransactionLSN: 120, Timestamp: ts},
+		StreamAbort{XID: 7, SubXID: 8},
+		BeginPrepare{LSN: 100, EndLSN: 120, Timestamp: ts, XID: 7, GID: "gid"},
+		Prepare{LSN: 100, EndLSN: 120, Timestamp: ts, XID: 7, GID: "gid"},
+		CommitPrepared{LSN: 130, EndLSN: 140, Timestamp: ts, XID: 7, GID: "gid"},
+		RollbackPrepared{PrepareEndLSN: 120, EndLSN: 140, PrepareTimestamp: ts, Timestamp: ts, XID: 7, GID: "gid"},
+		StreamPrepare{LSN: 100, EndLSN: 120, Timestamp: ts, XID: 7, GID: "gid"},
+	}
+	streamed = []Message{
+		Relation{XID: 7, ID: 16384, Namespace: "public", Name: "users", Replica: 'f', Columns: columns},
+		Type{XID: 7, ID: 16400, Namespace: "public", Name: "mood"},
+		Insert{XID: 7, RelationID: 16384, New: true, Row: row},
+		Update{XID: 7, RelationID: 16384, Key: true, OldRow: row[:1], New: true, Row: row},
+		Delete{XID: 7, RelationID: 16384, Old: true, Row: row},
+		Truncate{XID: 7, RelationIDs: []uint32{16384}},
+		LogicalMessage{XID: 7, Transactional: true, LSN: 110, Prefix: "app", Content: []byte("hello")},
+	}
+	return
+}
+
+func encodeSeeds(t testing.TB, messages []Message) [][]byte {
+	seeds := make([][]byte, len(messages))
+	for i, msg := range messages {
+		src, err := Encode(msg)
+		if err != nil {
+			t.Fatalf("encode %T: %s", msg, err)
+		}
+		seeds[i] = src
+	}
+	return seeds
+}
+
+func TestParseTruncated(t *testing.T) {
+	messages, streamed := parseSeeds()
+	check := func(parse func([]byte) (Message, error), seeds [][]byte) {
+		for _, src := range seeds {
+			if _, err := parse(src); err != nil {
+				t.Errorf("%q: %s", src[0], err)
+				continue
+			}
+			// 截断的消息均应返回错误
+			for n := 0; n < len(src); n++ {
+				if msg, err := parse(src[:n]); err == nil {
+					t.Errorf("%q truncated to %d bytes: parsed as %#v", src[0], n, msg)
+				}
+			}
+		}
+	}
+	check(Parse, encodeSeeds(t, messages))
+	check(ParseStream, encodeSeeds(t, streamed))
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		src  []byte
+	}{
+		{"unknown type", []byte{'Z', 0, 0}},
+		{"unterminated string", []byte{'O', 0, 0, 0, 0, 0, 0, 0, 1, 'a', 'b'}},
+		{"unknown tuple flag", []byte{'I', 0, 0, 0, 1, 'N', 0, 1, 'x'}},
+		{"tuple length overflow", []byte{'I', 0, 0, 0, 1, 'N', 0, 1, 't', 0xff, 0xff, 0xff, 0xff}},
+		{"truncate count overflow", []byte{'T', 0xff, 0xff, 0xff, 0xff, 0}},
+	}
+	for _, tt := range tests {
+		if msg, err := Parse(tt.src); err == nil {
+			t.Errorf("%s: parsed as %#v", tt.name, msg)
+		}
+	}
+}
+
+// 任意输入均不应panic，且返回的消息与错误有且仅有一个
+// Parse成功的消息经Encode后应解析为相同的消息
+func FuzzParse(f *testing.F) {
+	messages, streamed := parseSeeds()
+	for _, src := range encodeSeeds(f, append(messages, streamed...)) {
+		f.Add(src)
+	}
+	f.Fuzz(func(t *testing.T, src []byte) {
+		for _, parse := range []func([]byte) (Message, error){Parse, ParseStream} {
+			msg, err := parse(src)
+			if (msg == nil) == (err == nil) {
+				t.Fatalf("%x: message %#v, error %v", src, msg, err)
+			}
+		}
+		msg, err := Parse(src)
+		if err != nil {
+			return
+		}
+		buf, err := Encode(msg)
+		if err != nil {
+			t.Fatalf("%x: encode %#v: %s", src, msg, err)
+		}
+		got, err := Parse(buf)
+		if err != nil {
+			t.Fatalf("%x: reparse %x: %s", src, buf, err)
+		}
+		if !reflect.DeepEqual(got, msg) {
+			t.Fatalf("%x: round trip:\n got %#v\nwant %#v", src, got, msg)
+		}
+	})
+}
@@ -0,0 +1,232 @@
+package core
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// OutputPlugin 逻辑解码输出插件
+// 插件保存事务解码状态，每个Replication需使用独立的实例
+type OutputPlugin interface {
+	// Name 插件名称，用于CREATE_REPLICATION_SLOT
+	Name() string
+	// SlotOptions CREATE_REPLICATION_SLOT附加选项
+	SlotOptions(t *Replication) []string
+	// StartArgs START_REPLICATION插件参数
+	StartArgs(t *Replication) []string
+	// Decode 解码一条wal消息
+	// 变更消息缓存至事务结束事件(EventType_COMMIT等)后一并推送给handler
+	Decode(t *Replication, message *pgx.WalMessage) ([]ReplicationMessage, error)
+	// Reset 断线重连时清空未完成的事务状态，服务端将从已确认的lsn重新发送
+	Reset()
+}
+
+// 补全变更消息的lsn与所属事务
+func withTransaction(m ReplicationMessage, tx *Transaction, lsn uint64) ReplicationMessage {
+	m.Lsn = lsn
+	if tx != nil {
+		m.Xid = tx.Xid
+		m.Tx = tx
+	}
+	return m
+}
+
+// 插件输出的timestamptz文本，如 2019-12-29 04:58:34.806671+00
+func parseTimestamptz(s string) (ts time.Time, err error) {
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999-07", "2006-01-02 15:04:05.999999999-07:00"} {
+		if ts, err = time.Parse(layout, s); err == nil {
+			return
+		}
+	}
+	return
+}
+
+// PgOutput 内置pgoutput插件(PG10+)
+// 协议版本与参数由Replication的Streaming/TwoPhase/Messages/Binary/OriginNone决定
+type PgOutput struct {
+	stream    bool   //当前处于Stream Start与Stream Stop之间
+	streamXid uint32 //当前流式事务块xid
+	tx        *Transaction
+	streamTx  map[uint32]*Transaction //进行中的流式事务
+}
+
+func (p *PgOutput) Name() string {
+	return "pgoutput"
+}
+
+func (p *PgOutput) SlotOptions(t *Replication) []string {
+	options := []string{"NOEXPORT_SNAPSHOT"}
+	if t.twoPhase {
+		options = append(options, "TWO_PHASE")
+	}
+	return options
+}
+
+func (p *PgOutput) Reset() {
+	p.stream, p.streamXid, p.tx, p.streamTx = false, 0, nil, nil
+}
+
+func (p *PgOutput) StartArgs(t *Replication) []string {
+	version := "1"
+	if t.twoPhase {
+		version = "3"
+	} else if t.streaming {
+		version = "2"
+	}
+	args := []string{fmt.Sprintf(`proto_version '%s'`, version), fmt.Sprintf(`publication_names '%s'`, t.name)}
+	if t.streaming {
+		args = append(args, `streaming 'on'`)
+	}
+	if t.twoPhase {
+		args = append(args, `two_phase 'on'`)
+	}
+	if t.messages {
+		args = append(args, `messages 'true'`)
+	}
+	if t.binary {
+		args = append(args, `binary 'true'`)
+	}
+	if t.originNone {
+		args = append(args, `origin 'none'`)
+	}
+	return args
+}
+
+func (p *PgOutput) Decode(t *Replication, message *pgx.WalMessage) (res []ReplicationMessage, err error) {
+	parse := Parse
+	if p.stream {
+		parse = ParseStream
+	}
+	msg, err := parse(message.WalData)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pgoutput message: %s", err)
+	}
+	var m ReplicationMessage
+	switch v := msg.(type) {
+	case Begin:
+		p.tx = &Transaction{Xid: uint32(v.XID), BeginLsn: message.WalStart, CommitLsn: v.LSN, CommitTime: v.Timestamp}
+	case BeginPrepare:
+		p.tx = &Transaction{Xid: v.XID, BeginLsn: message.WalStart, CommitLsn: v.LSN, EndLsn: v.EndLSN, CommitTime: v.Timestamp}
+	case Origin:
+		if p.tx != nil {
+			p.tx.Origin = v.Name
+			p.tx.OriginLsn = v.LSN
+		}
+	case Relation:
+		if change := t.set.Add(v); change != nil {
+			sm := ReplicationMessage{EventType: EventType_SCHEMA_CHANGE, RelationID: v.ID, SchemaName: v.Namespace, TableName: v.Name, Schema: change}
+			res = append(res, p.change(sm, message.WalStart))
+		}
+	case Type:
+		t.set.AddType(v)
+	case Insert:
+		m, err = t.dump(EventType_INSERT, v.RelationID, v.Row, nil, false)
+		m.SubXid = v.XID
+	case Update:
+		m, err = t.dump(EventType_UPDATE, v.RelationID, v.Row, v.OldRow, v.Key)
+		m.SubXid = v.XID
+	case Delete:
+		m, err = t.dump(EventType_DELETE, v.RelationID, v.Row, nil, v.Key)
+		m.SubXid = v.XID
+	case Truncate:
+		// TRUNCATE a, b 每张表各推送一条消息
+		for _, relation := range v.RelationIDs {
+			tm, _ := t.dump(EventType_TRUNCATE, relation, nil, nil, false)
+			tm.SubXid = v.XID
+			tm.Cascade = v.Cascade()
+			tm.RestartIdentity = v.RestartIdentity()
+			res = append(res, p.change(tm, message.WalStart))
+		}
+	case LogicalMessage:
+		lm := ReplicationMessage{
+			EventType:     EventType_MESSAGE,
+			Lsn:           v.LSN,
+			SubXid:        v.XID,
+			Transactional: v.Transactional,
+			Prefix:        v.Prefix,
+			Content:       v.Content,
+		}
+		if v.Transactional {
+			lm = p.change(lm, v.LSN)
+		}
+		res = append(res, lm)
+	case StreamStart:
+		p.stream = true
+		p.streamXid = v.XID
+		if p.streamTx == nil {
+			p.streamTx = make(map[uint32]*Transaction)
+		}
+		if _, ok := p.streamTx[v.XID]; !ok {
+			p.streamTx[v.XID] = &Transaction{Xid: v.XID, BeginLsn: message.WalStart}
+		}
+		p.tx = p.streamTx[v.XID]
+	case StreamStop:
+		res = append(res, ReplicationMessage{EventType: EventType_STREAM_STOP, Xid: p.streamXid, Lsn: message.WalStart, Tx: p.tx})
+		p.stream = false
+		p.streamXid = 0
+		p.tx = nil
+	case StreamCommit:
+		tx := p.transaction(v.XID, message.WalStart)
+		tx.CommitLsn, tx.EndLsn, tx.CommitTime = v.LSN, v.TransactionLSN, v.Timestamp
+		delete(p.streamTx, v.XID)
+		res = append(res, ReplicationMessage{EventType: EventType_STREAM_COMMIT, Xid: v.XID, SubXid: v.XID, Lsn: message.WalStart, Tx: tx})
+	case StreamAbort:
+		tx := p.transaction(v.XID, message.WalStart)
+		if v.XID == v.SubXID {
+			delete(p.streamTx, v.XID)
+		}
+		res = append(res, ReplicationMessage{EventType: EventType_STREAM_ABORT, Xid: v.XID, SubXid: v.SubXID, Lsn: message.WalStart, Tx: tx})
+	case Commit:
+		tx := p.tx
+		if tx == nil {
+			tx = &Transaction{}
+		}
+		tx.CommitLsn, tx.EndLsn, tx.CommitTime = v.LSN, v.TransactionLSN, v.Timestamp
+		p.tx = nil
+		res = append(res, ReplicationMessage{EventType: EventType_COMMIT, Xid: tx.Xid, Lsn: message.WalStart, Tx: tx})
+	case Prepare:
+		tx := p.tx
+		if tx == nil {
+			tx = &Transaction{Xid: v.XID}
+		}
+		tx.CommitLsn, tx.EndLsn, tx.CommitTime = v.LSN, v.EndLSN, v.Timestamp
+		p.tx = nil
+		res = append(res, ReplicationMessage{EventType: EventType_PREPARE, Xid: v.XID, Gid: v.GID, Lsn: message.WalStart, Tx: tx})
+	case CommitPrepared:
+		tx := &Transaction{Xid: v.XID, BeginLsn: message.WalStart, CommitLsn: v.LSN, EndLsn: v.EndLSN, CommitTime: v.Timestamp}
+		res = append(res, ReplicationMessage{EventType: EventType_COMMIT_PREPARED, Xid: v.XID, Gid: v.GID, Lsn: message.WalStart, Tx: tx})
+	case RollbackPrepared:
+		tx := &Transaction{Xid: v.XID, BeginLsn: message.WalStart, EndLsn: v.EndLSN, CommitTime: v.Timestamp}
+		res = append(res, ReplicationMessage{EventType: EventType_ROLLBACK_PREPARED, Xid: v.XID, Gid: v.GID, Lsn: message.WalStart, Tx: tx})
+	case StreamPrepare:
+		tx := p.transaction(v.XID, message.WalStart)
+		tx.CommitLsn, tx.EndLsn, tx.CommitTime = v.LSN, v.EndLSN, v.Timestamp
+		delete(p.streamTx, v.XID)
+		res = append(res, ReplicationMessage{EventType: EventType_STREAM_PREPARE, Xid: v.XID, SubXid: v.XID, Gid: v.GID, Lsn: message.WalStart, Tx: tx})
+	}
+	if err != nil {
+		return nil, err
+	}
+	if m.RelationID > 0 {
+		res = append(res, p.change(m, message.WalStart))
+	}
+	return
+}
+
+// 获取流式事务信息，未收到过Stream Start时新建
+func (p *PgOutput) transaction(xid uint32, lsn uint64) *Transaction {
+	if tx, ok := p.streamTx[xid]; ok {
+		return tx
+	}
+	return &Transaction{Xid: xid, BeginLsn: lsn}
+}
+
+// 补全变更消息的lsn与所属事务，流式事务块中无事务信息时使用块xid
+func (p *PgOutput) change(m ReplicationMessage, lsn uint64) ReplicationMessage {
+	if p.stream {
+		m.Xid = p.streamXid
+	}
+	return withTransaction(m, p.tx, lsn)
+}
@@ -0,0 +1,147 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/pgtype"
+)
+
+func encodeAll(t *testing.T, messages ...Message) [][]byte {
+	t.Helper()
+	data := make([][]byte, len(messages))
+	for i, msg := range messages {
+		src, err := Encode(msg)
+		if err != nil {
+			t.Fatalf("encode %#v: %s", msg, err)
+		}
+		data[i] = src
+	}
+	return data
+}
+
+// 单列表public.t(id int4, v oid)
+func nullRelation(oid uint32) Relation {
+	return Relation{ID: 1, Namespace: "public", Name: "t", Replica: 'f', Columns: []Column{
+		{Key: true, Name: "id", Type: pgtype.Int4OID},
+		{Name: "v", Type: oid},
+	}}
+}
+
+func TestPgOutputNull(t *testing.T) {
+	id := Tuple{Flag: 't', Value: []byte("1")}
+	null := Tuple{Flag: 'n'}
+	oids := []uint32{99999}
+	for _, tt := range decoderTests {
+		oids = append(oids, tt.oid)
+	}
+	for _, normalize := range []bool{false, true} {
+		for _, oid := range oids {
+			r := NewReplication("test_slot", pgx.ConnConfig{})
+			if normalize {
+				r.Normalize()
+			}
+			res := testDecode(t, r, &PgOutput{}, encodeAll(t,
+				nullRelation(oid),
+				Insert{RelationID: 1, New: true, Row: []Tuple{id, null}},
+				Update{RelationID: 1, Old: true, OldRow: []Tuple{{Flag: 't', Value: []byte("2")}, null}, New: true, Row: []Tuple{id, null}},
+				Delete{RelationID: 1, Old: true, Row: []Tuple{id, null}},
+			)...)
+			for _, m := range res[len(res)-3:] {
+				if value, ok := m.Body["v"]; !ok || value != nil {
+					t.Errorf("normalize %v, oid %d, %v: got %#v, want nil", normalize, oid, m.EventType, value)
+				}
+			}
+			if value, ok := res[len(res)-2].OldBody["v"]; !ok || value != nil {
+				t.Errorf("normalize %v, oid %d: update old value %#v, want nil", normalize, oid, value)
+			}
+		}
+	}
+}
+
+func TestPgOutputColumnCountMismatch(t *testing.T) {
+	id := Tuple{Flag: 't', Value: []byte("1")}
+	tests := []Message{
+		Insert{RelationID: 1, New: true, Row: []Tuple{id}},
+		Insert{RelationID: 1, New: true, Row: []Tuple{id, id, id}},
+		Update{RelationID: 1, New: true, Row: []Tuple{id}},
+		Update{RelationID: 1, Key: true, OldRow: []Tuple{id}, New: true, Row: []Tuple{id, id}},
+		Delete{RelationID: 1, Old: true, Row: []Tuple{id, id, id}},
+	}
+	for _, msg := range tests {
+		p := &PgOutput{}
+		r := NewReplication("test_slot", pgx.ConnConfig{}).Plugin(p)
+		data := encodeAll(t, nullRelation(pgtype.TextOID), msg)
+		if _, err := p.Decode(r, &pgx.WalMessage{WalData: data[0]}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.Decode(r, &pgx.WalMessage{WalData: data[1]}); err == nil {
+			t.Errorf("%#v: expected column count error", msg)
+		}
+	}
+}
+
+func TestWal2JsonNull(t *testing.T) {
+	res := testDecode(t, nil, &Wal2Json{}, testDecodingLines(
+		`{"action":"I","schema":"public","table":"t","columns":[{"name":"id","type":"integer","value":1},`+
+			`{"name":"a","type":"text","value":null},{"name":"b","type":"numeric","value":null},{"name":"c","type":"integer[]","value":null},`+
+			`{"name":"m","type":"money","value":"$1,000.00"}]}`,
+		`{"action":"D","schema":"public","table":"t","identity":[{"name":"id","type":"integer","value":1},{"name":"a","type":"text","value":null}]}`,
+	)...)
+	for _, name := range []string{"a", "b", "c"} {
+		if value, ok := res[0].Body[name]; !ok || value != nil {
+			t.Errorf("insert %s: got %#v, want nil", name, value)
+		}
+	}
+	if value, ok := res[1].Body["a"]; !ok || value != nil {
+		t.Errorf("delete a: got %#v, want nil", value)
+	}
+	if value := res[0].Body["m"]; value != int64(100000) {
+		t.Errorf("insert m: got %#v, want 100000 cents", value)
+	}
+}
+
+func TestPgOutputDeleteKeyOnly(t *testing.T) {
+	res := testDecode(t, nil, &PgOutput{}, encodeAll(t,
+		nullRelation(pgtype.TextOID),
+		Delete{RelationID: 1, Key: true, Row: []Tuple{{Flag: 't', Value: []byte("1")}, {Flag: 'n'}}},
+		Delete{RelationID: 1, Old: true, Row: []Tuple{{Flag: 't', Value: []byte("1")}, {Flag: 'n'}}},
+	)...)
+	key, full := res[len(res)-2], res[len(res)-1]
+	if !key.OldKeyOnly || len(key.Body) != 1 || len(key.OldBody) != 1 || key.Body["id"] != int32(1) {
+		t.Errorf("key-only delete: body %v, old body %v, key only %v", key.Body, key.OldBody, key.OldKeyOnly)
+	}
+	if _, ok := full.Body["v"]; full.OldKeyOnly || !ok || len(full.OldBody) != 2 {
+		t.Errorf("full delete: body %v, old body %v, key only %v", full.Body, full.OldBody, full.OldKeyOnly)
+	}
+}
+
+func TestPgOutputOptions(t *testing.T) {
+	tests := []struct {
+		r        *Replication
+		twoPhase bool
+		args     []string
+	}{
+		{NewReplication("test_slot", pgx.ConnConfig{}), false, []string{`proto_version '1'`}},
+		{NewReplication("test_slot", pgx.ConnConfig{}).Streaming(), false, []string{`proto_version '2'`, `streaming 'on'`}},
+		{NewReplication("test_slot", pgx.ConnConfig{}).TwoPhase(), true, []string{`proto_version '3'`, `two_phase 'on'`}},
+		{NewReplication("test_slot", pgx.ConnConfig{}).Streaming().TwoPhase(), true, []string{`proto_version '3'`, `streaming 'on'`, `two_phase 'on'`}},
+	}
+	for _, tt := range tests {
+		p := &PgOutput{}
+		options := strings.Join(p.SlotOptions(tt.r), " ")
+		if strings.Contains(options, "TWO_PHASE") != tt.twoPhase {
+			t.Errorf("slot options %q, want TWO_PHASE %v", options, tt.twoPhase)
+		}
+		args := strings.Join(p.StartArgs(tt.r), ", ")
+		for _, arg := range tt.args {
+			if !strings.Contains(args, arg) {
+				t.Errorf("start args %q, want %s", args, arg)
+			}
+		}
+		if strings.Contains(args, "two_phase") != tt.twoPhase {
+			t.Errorf("start args %q, want two_phase %v", args, tt.twoPhase)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// ReconnectOptions 断线重连策略，零值字段使用默认值
+type ReconnectOptions struct {
+	MinBackoff time.Duration // 首次重连等待，默认1s
+	MaxBackoff time.Duration // 最大等待，默认1min
+	Multiplier float64       // 等待时间倍数，默认2
+	Jitter     float64       // 随机抖动比例(0~1)，默认0.2
+	MaxRetries int           // 连续失败次数上限，0为不限
+	// OnReconnect 每次重连前回调
+	OnReconnect func(event ReconnectEvent)
+}
+
+// ReconnectEvent 重连事件
+type ReconnectEvent struct {
+	Attempt int           // 连续失败次数，从1开始
+	Err     error         // 导致重连的错误
+	Delay   time.Duration // 本次重连前的等待时间
+	Lsn     uint64        // 恢复复制的起始lsn(最后确认的lsn)，0为复制槽的confirmed_flush_lsn
+}
+
+// 消息解码(含类型转换、脱敏)出错，重连后会重复出错，不重连
+type handleError struct {
+	error
+}
+
+func (e handleError) Unwrap() error {
+	return e.error
+}
+
+// 会话开始前服务端返回的错误(认证失败、复制槽或发布不存在、权限不足等)重连后仍会出错
+// 连接异常(08)、资源不足(53，如连接数已满)、服务端关闭或启动中(57)及复制槽被占用(55006)可重试
+func permanentError(err error) bool {
+	var pgErr pgx.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(pgErr.Code, "08"), strings.HasPrefix(pgErr.Code, "53"), strings.HasPrefix(pgErr.Code, "57"), pgErr.Code == "55006":
+		return false
+	}
+	return true
+}
+
+// Reconnect 断线时自动重连
+// 重连后从最后确认(SendStatusACK)的lsn重新开始复制，未确认的事务会重新推送
+// 未完成的事务缓存、插件状态及Relation缓存均被清空，由新会话的Relation消息重建
+// 断线期间的表结构变更在新会话收到Relation消息时推送EventType_SCHEMA_CHANGE
+// 开始复制前服务端返回的错误(如认证失败、复制槽不存在)不重连，直接返回
+func (t *Replication) Reconnect(options ReconnectOptions) *Replication {
+	if options.MinBackoff <= 0 {
+		options.MinBackoff = time.Second
+	}
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = time.Minute
+	}
+	if options.MaxBackoff < options.MinBackoff {
+		options.MaxBackoff = options.MinBackoff
+	}
+	if options.Multiplier < 1 {
+		options.Multiplier = 2
+	}
+	if options.Jitter <= 0 || options.Jitter > 1 {
+		options.Jitter = 0.2
+	}
+	t.reconnect = &options
+	return t
+}
+
+// 第attempt次重连前的等待时间，指数退避并加入随机抖动
+func (o *ReconnectOptions) backoff(attempt int) time.Duration {
+	delay := float64(o.MinBackoff) * math.Pow(o.Multiplier, float64(attempt-1))
+	if delay > float64(o.MaxBackoff) {
+		delay = float64(o.MaxBackoff)
+	}
+	delay *= 1 + o.Jitter*(rand.Float64()*2-1)
+	return time.Duration(delay)
+}
+
+// 运行复制会话，连接类错误按策略重连
+func (t *Replication) supervise(ctx context.Context, dmlHandler ReplicationDMLHandler) error {
+	setup := true
+	attempt := 0
+	for {
+		started, err := t.run(ctx, dmlHandler, setup)
+		if started {
+			setup = false
+			attempt = 0
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == nil || errors.As(err, &handleError{}) {
+			return err
+		}
+		if !started && permanentError(err) {
+			return err
+		}
+		attempt++
+		if t.reconnect.MaxRetries > 0 && attempt > t.reconnect.MaxRetries {
+			return err
+		}
+		t.resetSession()
+		event := ReconnectEvent{Attempt: attempt, Err: err, Delay: t.reconnect.backoff(attempt), Lsn: t._ackLsn}
+		t.debug("reconnect:", event.Attempt, event.Delay, err)
+		if t.reconnect.OnReconnect != nil {
+			t.reconnect.OnReconnect(event)
+		}
+		timer := time.NewTimer(event.Delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// 清空会话状态，新会话从最后确认的lsn重新发送
+func (t *Replication) resetSession() {
+	if t._conn != nil && t._conn.IsAlive() {
+		t._conn.Close()
+	}
+	t._conn = nil
+	t._flushMsg = nil
+	t._pendLsn = 0
+	t.plugin.Reset()
+	t.set.Reset()
+}
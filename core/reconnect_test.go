@@ -0,0 +1,141 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/pgtype"
+)
+
+func TestRelationSetResetBaseline(t *testing.T) {
+	rs := NewRelationSet()
+	rel := nullRelation(pgtype.TextOID)
+	rs.Add(rel)
+	rs.Reset()
+	if change := rs.Add(rel); change != nil {
+		t.Errorf("unchanged relation after reset: %+v", change)
+	}
+	// 连续两次断线，期间未重新收到Relation
+	rs.Reset()
+	rs.Reset()
+	altered := rel
+	altered.Columns = append(append([]Column{}, rel.Columns...), Column{Name: "added", Type: pgtype.Int8OID})
+	change := rs.Add(altered)
+	if change == nil || len(change.Added) != 1 || change.Added[0].Name != "added" {
+		t.Fatalf("altered relation after reset: %+v", change)
+	}
+	// 基准仅使用一次
+	rs.Reset()
+	if change := rs.Add(altered); change != nil {
+		t.Errorf("second reset: %+v", change)
+	}
+}
+
+func TestReconnectSchemaChange(t *testing.T) {
+	p := &PgOutput{}
+	r := NewReplication("test_slot", pgx.ConnConfig{}).Plugin(p)
+	rel := nullRelation(pgtype.TextOID)
+	testDecode(t, r, p, encodeAll(t, rel)...)
+	r.resetSession()
+	rel.Columns = rel.Columns[:1]
+	res := testDecode(t, r, p, encodeAll(t, rel)...)
+	if len(res) != 1 || res[0].EventType != EventType_SCHEMA_CHANGE || len(res[0].Schema.Dropped) != 1 {
+		t.Fatalf("got %+v, want SCHEMA_CHANGE dropping v", res)
+	}
+}
+
+type failingCheckpointStore struct{}
+
+func (failingCheckpointStore) Load(slot string) (*Checkpoint, error) { return nil, nil }
+func (failingCheckpointStore) Save(slot string, checkpoint Checkpoint) error {
+	return errors.New("disk full")
+}
+
+func TestHandleError(t *testing.T) {
+	handler := func(msg ...ReplicationMessage) DMLHandlerStatus { return DMLHandlerStatusSuccess }
+	r := NewReplication("test_slot", pgx.ConnConfig{}).Checkpoint(failingCheckpointStore{})
+	// 解码错误不重连
+	err := r.handle(&pgx.WalMessage{WalData: []byte{'Z'}}, handler)
+	if !errors.As(err, &handleError{}) {
+		t.Errorf("decode error: got %v, want handleError", err)
+	}
+	// 保存进度(确认lsn)失败可重连
+	for _, src := range encodeAll(t,
+		Begin{LSN: 100, XID: 1},
+		nullRelation(pgtype.TextOID),
+		Insert{RelationID: 1, New: true, Row: []Tuple{{Flag: 't', Value: []byte("1")}, {Flag: 'n'}}},
+		Commit{LSN: 100, TransactionLSN: 120},
+	) {
+		err = r.handle(&pgx.WalMessage{WalStart: 100, WalData: src}, handler)
+	}
+	if err == nil || errors.As(err, &handleError{}) {
+		t.Errorf("checkpoint error: got %v, want plain error", err)
+	}
+}
+
+// 替换复制连接的建立，返回恢复函数
+func stubConnect(connect func() (replicationConn, error)) func() {
+	original := replicationConnect
+	replicationConnect = func(config pgx.ConnConfig) (replicationConn, error) {
+		return connect()
+	}
+	return func() { replicationConnect = original }
+}
+
+func TestSupervise(t *testing.T) {
+	authFailed := pgx.PgError{Severity: "FATAL", Code: "28P01", Message: "password authentication failed"}
+	dials := 0
+	tests := []struct {
+		name     string
+		connect  func() (replicationConn, error)
+		attempts int //OnReconnect回调次数
+		pgError  bool
+	}{
+		{"auth failed", func() (replicationConn, error) { return nil, authFailed }, 0, true},
+		{"network error", func() (replicationConn, error) { return nil, errors.New("dial tcp: connection refused") }, 2, false},
+		{"too many connections", func() (replicationConn, error) {
+			return nil, pgx.PgError{Severity: "FATAL", Code: "53300", Message: "too many connections"}
+		}, 2, true},
+		{"slot missing", func() (replicationConn, error) {
+			return &fakeConn{startErr: pgx.PgError{Severity: "ERROR", Code: "42704", Message: `replication slot "test_slot" does not exist`}}, nil
+		}, 0, true},
+		{"slot in use", func() (replicationConn, error) {
+			return &fakeConn{startErr: pgx.PgError{Severity: "ERROR", Code: "55006", Message: `replication slot "test_slot" is active`}}, nil
+		}, 2, true},
+		// 开始复制后服务端返回的错误重连，重连时认证失败则返回
+		{"terminated after start", func() (replicationConn, error) {
+			if dials++; dials > 1 {
+				return nil, authFailed
+			}
+			return &fakeConn{waitErr: pgx.PgError{Severity: "FATAL", Code: "XX000", Message: "terminating connection"}}, nil
+		}, 1, true},
+	}
+	for _, tt := range tests {
+		restore := stubConnect(tt.connect)
+		var events []ReconnectEvent
+		r := NewReplication("test_slot", pgx.ConnConfig{}).Plugin(&TestDecoding{}).Reconnect(ReconnectOptions{
+			MinBackoff: time.Millisecond,
+			MaxRetries: 2,
+			OnReconnect: func(event ReconnectEvent) {
+				events = append(events, event)
+			},
+		})
+		err := r.Start(context.Background(), func(msgs ...ReplicationMessage) DMLHandlerStatus {
+			return DMLHandlerStatusSuccess
+		})
+		restore()
+		if err == nil {
+			t.Errorf("%s: expected error", tt.name)
+			continue
+		}
+		if len(events) != tt.attempts {
+			t.Errorf("%s: %d reconnects, want %d: %v", tt.name, len(events), tt.attempts, err)
+		}
+		if pgError := errors.As(err, &pgx.PgError{}); pgError != tt.pgError {
+			t.Errorf("%s: got %v, PgError %v, want %v", tt.name, err, pgError, tt.pgError)
+		}
+	}
+}
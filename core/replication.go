@@ -1,13 +1,17 @@
 package core
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"github.com/cube-group/pg-replication/pkg/utils"
 	"github.com/jackc/pgx"
 	"github.com/jackc/pgx/pgtype"
 	"log"
+	"math"
+	"reflect"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 )
@@ -30,21 +34,58 @@ const (
 	ReplicaIdentityDefault ReplicaIdentity = "DEFAULT"
 )
 
+// 复制连接，*pgx.ReplicationConn实现该接口
+type replicationConn interface {
+	IsAlive() bool
+	Close() error
+	Exec(sql string, arguments ...interface{}) (pgx.CommandTag, error)
+	Query(sql string, args ...interface{}) (*pgx.Rows, error)
+	StartReplication(slotName string, startLsn uint64, timeline int64, pluginArguments ...string) error
+	WaitForReplicationMessage(ctx context.Context) (*pgx.ReplicationMessage, error)
+	SendStandbyStatus(k *pgx.StandbyStatus) error
+}
+
 type Replication struct {
 	_debug    bool
-	_conn     *pgx.ReplicationConn
+	_conn     replicationConn
 	_flushMsg []ReplicationMessage
+	_skipGid  map[string]bool //已丢弃的两阶段事务
+	_ackLsn   uint64          //最后确认的lsn，重连时由此继续
+	_pendLsn  uint64          //已推送但handler未确认的最后事务lsn
 
-	name   string
-	config pgx.ConnConfig
-	set    *RelationSet
+	name      string
+	config    pgx.ConnConfig
+	set       *RelationSet
+	plugin    OutputPlugin
+	streaming bool
+	twoPhase  bool
+	messages  bool
+	binary    bool
+	normalize bool
+	// 复制源过滤
+	originNone  bool
+	skipOrigins map[string]bool
+	// 列投影与脱敏规则，key为schema.table.column
+	masks map[string]MaskRule
+	// 断线重连策略
+	reconnect *ReconnectOptions
+	// 复制进度存储
+	checkpoint     CheckpointStore
+	checkpointMeta map[string]string
 }
 
 func NewReplication(name string, config pgx.ConnConfig) *Replication {
 	if !regexp.MustCompile(`[a-z0-9_]{3,64}`).MatchString(name) {
 		log.Fatal("name invalid")
 	}
-	return &Replication{name: name, config: config, set: NewRelationSet()}
+	return &Replication{name: name, config: config, set: NewRelationSet(), plugin: &PgOutput{}}
+}
+
+// Plugin 指定逻辑解码输出插件，默认PgOutput
+// 需在CreateReplication之前调用
+func (t *Replication) Plugin(plugin OutputPlugin) *Replication {
+	t.plugin = plugin
+	return t
 }
 
 func (t *Replication) Debug() *Replication {
@@ -52,9 +93,106 @@ func (t *Replication) Debug() *Replication {
 	return t
 }
 
-func (t *Replication) conn() (*pgx.ReplicationConn, error) {
+// Streaming 开启pgoutput protocol v2流式传输进行中的大事务(PG14+)
+// 大事务在提交前即按块(Stream Start~Stream Stop)推送，每块以EventType_STREAM_STOP结尾，消息带有Xid
+// 事务最终以EventType_STREAM_COMMIT或EventType_STREAM_ABORT结束，收到ABORT时需丢弃对应Xid/SubXid的变更
+func (t *Replication) Streaming() *Replication {
+	t.streaming = true
+	return t
+}
+
+// TwoPhase 开启pgoutput protocol v3两阶段事务解码(PG15+)
+// 需在CreateReplication之前调用，复制槽将以TWO_PHASE创建
+// PREPARE TRANSACTION时即推送变更并以EventType_PREPARE结尾，最终由EventType_COMMIT_PREPARED或EventType_ROLLBACK_PREPARED确认或丢弃(按Gid)
+func (t *Replication) TwoPhase() *Replication {
+	t.twoPhase = true
+	return t
+}
+
+// Messages 接收pg_logical_emit_message()写入的逻辑解码消息(PG14+)，以EventType_MESSAGE推送
+// 事务性消息随所属事务一同推送，非事务性消息收到即单独推送且不记录游标
+func (t *Replication) Messages() *Replication {
+	t.messages = true
+	return t
+}
+
+// Binary 以二进制格式传输列值(PG14+)，省去时间/数值/数组等类型的文本解析
+// 无二进制send函数的类型服务端仍以文本格式传输
+// 无内置解码器的类型(xml、tsvector、pg_lsn、"char"、record及扩展类型等)列值为原始字节([]byte，Normalize时为base64)，
+// 可通过RegisterType/RegisterTypeOID注册支持DecodeBinary的解码器
+func (t *Replication) Binary() *Replication {
+	t.binary = true
+	return t
+}
+
+// Normalize Body/OldBody列值转换为可稳定序列化为JSON的值，映射见Normalize函数
+// 对pgoutput及test_decoding生效，wal2json的列值本身即为JSON值
+func (t *Replication) Normalize() *Replication {
+	t.normalize = true
+	return t
+}
+
+// RegisterType 按类型名("schema.name"或"name")注册自定义类型解码器，如扩展类型
+func (t *Replication) RegisterType(name string, decoder TypeDecoder) *Replication {
+	t.set.RegisterDecoder(name, decoder)
+	return t
+}
+
+// RegisterTypeOID 按类型OID注册解码器，可覆盖内置类型
+func (t *Replication) RegisterTypeOID(oid uint32, decoder TypeDecoder) *Replication {
+	t.set.RegisterOIDDecoder(oid, decoder)
+	return t
+}
+
+// RegisterConverter 注册列值转换器，key可为"schema.table.column"或类型名("schema.name"或"name")
+// 内置类型使用pg_type中的类型名，如"jsonb"、"pg_catalog.int4"、数组"_text"
+// 如将text列解析为JSON、将PostGIS geometry转为GeoJSON
+func (t *Replication) RegisterConverter(key string, converter Converter) *Replication {
+	t.set.RegisterConverter(key, converter)
+	return t
+}
+
+// RegisterOIDConverter 按类型OID注册列值转换器
+func (t *Replication) RegisterOIDConverter(oid uint32, converter Converter) *Replication {
+	t.set.RegisterOIDConverter(oid, converter)
+	return t
+}
+
+// OriginNone 仅接收本地写入(无复制源)的变更(PG16+)，由服务端过滤
+func (t *Replication) OriginNone() *Replication {
+	t.originNone = true
+	return t
+}
+
+// SkipOrigins 丢弃复制源名称在列表中的事务，用于双向同步防止回环
+// 被丢弃的事务不会推送给handler；此前推送的事务均已确认时记录其游标，避免confirmed_flush_lsn停滞
+func (t *Replication) SkipOrigins(names ...string) *Replication {
+	if t.skipOrigins == nil {
+		t.skipOrigins = make(map[string]bool)
+	}
+	for _, name := range names {
+		t.skipOrigins[name] = true
+	}
+	return t
+}
+
+// 事务是否需要丢弃
+func (t *Replication) skipped(tx *Transaction) bool {
+	return tx != nil && tx.Origin != "" && t.skipOrigins[tx.Origin]
+}
+
+// 建立复制连接，测试时可替换
+var replicationConnect = func(config pgx.ConnConfig) (replicationConn, error) {
+	conn, err := pgx.ReplicationConnect(config)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (t *Replication) conn() (replicationConn, error) {
 	if t._conn == nil || !t._conn.IsAlive() {
-		conn, err := pgx.ReplicationConnect(t.config)
+		conn, err := replicationConnect(t.config)
 		if err != nil {
 			return nil, err
 		}
@@ -64,109 +202,269 @@ func (t *Replication) conn() (*pgx.ReplicationConn, error) {
 }
 
 // 组装ReplicationMessage
-func (t *Replication) dump(eventType EventType, relation uint32, row, oldRow []Tuple) (msg ReplicationMessage, err error) {
+// UPDATE的oldRow及DELETE的row为旧行，keyOnly表示旧行仅含复制标识列('K')，否则为整行('O')
+func (t *Replication) dump(eventType EventType, relation uint32, row, oldRow []Tuple, keyOnly bool) (msg ReplicationMessage, err error) {
 	msg.RelationID = relation
 	msg.EventType = eventType
 	msg.SchemaName, msg.TableName = t.set.Assist(relation)
 	if row == nil && oldRow == nil {
 		return
 	}
+	msg.Unchanged = t.set.Restore(relation, row, oldRow)
 	values, err := t.set.Values(relation, row)
 	if err != nil {
 		err = fmt.Errorf("error parsing values: %s", err)
 		return
 	}
-	if oldRow != nil {
-		if oldValues, er := t.set.Values(relation, oldRow); er == nil {
-			msg.Columns = t.dumpChangedColumns(values, oldValues)
-			if len(msg.Columns) == 0 { //没必要的update
-				return
-			}
+	var oldValues map[string]pgtype.Value
+	if eventType == EventType_DELETE {
+		// 仅含复制标识列时其余列均为NULL，不代表行中的值
+		if keyOnly {
+			values = t.set.Keys(relation, values)
+		}
+		oldValues = values
+	} else if oldRow != nil {
+		if oldValues, err = t.set.Values(relation, oldRow); err != nil {
+			err = fmt.Errorf("error parsing old values: %s", err)
+			return
+		}
+	}
+	if oldValues != nil {
+		if keyOnly {
+			oldValues = t.set.Keys(relation, oldValues)
 		}
+		msg.OldBody = t.dumpBody(oldValues)
+		msg.OldKeyOnly = keyOnly
 	}
+	body := t.dumpBody(values)
+	if eventType == EventType_UPDATE && oldValues != nil {
+		msg.Columns, msg.Changes = diffColumns(body, msg.OldBody)
+		if len(msg.Columns) == 0 { //没必要的update
+			return
+		}
+	}
+	msg.Body = body
+	return
+}
+
+func (t *Replication) dumpBody(values map[string]pgtype.Value) map[string]interface{} {
 	body := make(map[string]interface{}, 0)
 	for name, value := range values {
+		if t.normalize {
+			body[name] = Normalize(value)
+			continue
+		}
 		val := value.Get()
 		body[name] = val
 	}
-	msg.Body = body
-	return
+	return body
 }
 
-func (t *Replication) dumpChangedColumns(values, oldValues map[string]pgtype.Value) (res []string) {
-	if oldValues == nil || values == nil {
-		return nil
+// 比较新旧行，返回变更的列(按列名排序)及新旧值
+// 新行中缺失的列为未变更的TOAST列；仅存在于新行的列(旧行仅含复制标识列时)视为变更，旧值为nil
+func diffColumns(body, oldBody map[string]interface{}) (columns []string, changes map[string]ColumnChange) {
+	if body == nil || oldBody == nil {
+		return nil, nil
 	}
-	for k, v := range oldValues {
-		if newV, ok := values[k]; !ok || newV.Get() != v.Get() {
-			res = append(res, k)
+	for name, value := range body {
+		old, ok := oldBody[name]
+		if ok && valueEqual(value, old) {
+			continue
 		}
+		if changes == nil {
+			changes = make(map[string]ColumnChange)
+		}
+		changes[name] = ColumnChange{Old: old, New: value}
+		columns = append(columns, name)
 	}
+	sort.Strings(columns)
 	return
 }
 
+// 列值比较：pgtype值按文本格式比较，时间按时刻比较，浮点数NaN视为相等，其余深度比较(数组、jsonb、bytea等)
+func valueEqual(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	switch va := a.(type) {
+	case time.Time:
+		vb, ok := b.(time.Time)
+		return ok && va.Equal(vb)
+	case float64:
+		vb, ok := b.(float64)
+		return ok && (va == vb || math.IsNaN(va) && math.IsNaN(vb))
+	case float32:
+		vb, ok := b.(float32)
+		return ok && (va == vb || math.IsNaN(float64(va)) && math.IsNaN(float64(vb)))
+	}
+	if ea, ok := a.(pgtype.TextEncoder); ok {
+		if eb, ok := b.(pgtype.TextEncoder); ok {
+			ba, errA := ea.EncodeText(nil, nil)
+			bb, errB := eb.EncodeText(nil, nil)
+			if errA == nil && errB == nil {
+				return reflect.TypeOf(a) == reflect.TypeOf(b) && bytes.Equal(ba, bb)
+			}
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// 解码错误标记为handleError；保存进度及确认lsn的错误为连接类错误，可重连
+// 脱敏在插件解码之后、缓存或推送之前进行，对所有插件(含自定义插件)生效
 func (t *Replication) handle(message *pgx.WalMessage, dmlHandler ReplicationDMLHandler) error {
-	msg, err := Parse(message.WalData)
+	msgs, err := t.plugin.Decode(t, message)
 	if err != nil {
-		return fmt.Errorf("invalid pgoutput message: %s", err)
-	}
-	var m ReplicationMessage
-	switch v := msg.(type) {
-	case Begin:
-	case Relation:
-		if t._flushMsg == nil {
-			t._flushMsg = make([]ReplicationMessage, 0)
+		return handleError{err}
+	}
+	for i := range msgs {
+		if err = t.mask(&msgs[i]); err != nil {
+			return handleError{err}
+		}
+	}
+	return t.emit(msgs, dmlHandler)
+}
+
+// 按事件类型缓存或推送插件解码出的消息
+// 事务结束事件推送缓存消息并记录游标，流式事务块结束/回滚仅推送，结构变更及非事务性消息立即推送且不记录游标
+func (t *Replication) emit(msgs []ReplicationMessage, dmlHandler ReplicationDMLHandler) (err error) {
+	for _, m := range msgs {
+		switch m.EventType {
+		case EventType_COMMIT, EventType_STREAM_COMMIT, EventType_PREPARE, EventType_COMMIT_PREPARED, EventType_ROLLBACK_PREPARED, EventType_STREAM_PREPARE:
+			if err = t.flush(m, dmlHandler); err != nil {
+				return
+			}
+		case EventType_STREAM_STOP, EventType_STREAM_ABORT:
+			// 未提交的事务块，不记录游标
+			if !t.skipped(m.Tx) {
+				t._flushMsg = append(t._flushMsg, m)
+				dmlHandler(t._flushMsg...)
+			}
+			t._flushMsg = nil
+		case EventType_SCHEMA_CHANGE:
+			// Relation缓存已更新，不随所属事务丢弃(SkipOrigins/STREAM_ABORT/ROLLBACK PREPARED)，收到即单独推送
+			dmlHandler(m)
+		case EventType_MESSAGE:
+			if !m.Transactional {
+				dmlHandler(m)
+				continue
+			}
+			fallthrough
+		default:
+			if !t.skipped(m.Tx) {
+				t._flushMsg = append(t._flushMsg, m)
+			}
+		}
+	}
+	return
+}
+
+// 以end事件结尾推送缓存消息，handler返回成功时记录游标
+// 需丢弃的事务直接清空
+func (t *Replication) flush(end ReplicationMessage, dmlHandler ReplicationDMLHandler) error {
+	switch {
+	case t.skipped(end.Tx):
+		t.debug("skip origin:", end.Tx.Origin, end.Tx.Xid)
+		// COMMIT/ROLLBACK PREPARED不带复制源，按gid丢弃
+		if end.EventType == EventType_PREPARE || end.EventType == EventType_STREAM_PREPARE {
+			if t._skipGid == nil {
+				t._skipGid = make(map[string]bool)
+			}
+			t._skipGid[end.Gid] = true
 		}
-		t.set.Add(v)
-	case Insert:
-		m, err = t.dump(EventType_INSERT, v.RelationID, v.Row, nil)
-	case Update:
-		m, err = t.dump(EventType_UPDATE, v.RelationID, v.Row, v.OldRow)
-	case Delete:
-		m, err = t.dump(EventType_DELETE, v.RelationID, v.Row, nil)
-	case Truncate:
-		m, err = t.dump(EventType_TRUNCATE, v.RelationID, nil, nil)
-	case Commit:
-		t._flushMsg = append(t._flushMsg, ReplicationMessage{EventType: EventType_COMMIT, Lsn: message.WalStart})
-		status := dmlHandler(t._flushMsg...)
 		t._flushMsg = nil
-		if status == DMLHandlerStatusSuccess {
-			err = t.SendStatusACK(message.WalStart)
+		return t.ackSkipped(end)
+	case end.Gid != "" && t._skipGid[end.Gid]:
+		if end.EventType == EventType_COMMIT_PREPARED || end.EventType == EventType_ROLLBACK_PREPARED {
+			delete(t._skipGid, end.Gid)
 		}
+		t._flushMsg = nil
+		return t.ackSkipped(end)
 	}
-	if err != nil {
-		return err
+	t._flushMsg = append(t._flushMsg, end)
+	status := dmlHandler(t._flushMsg...)
+	t._flushMsg = nil
+	if status == DMLHandlerStatusSuccess {
+		return t.ack(end)
 	}
-	if m.RelationID > 0 {
-		m.Lsn = message.WalStart
-		t._flushMsg = append(t._flushMsg, m)
+	if end.Lsn > t._pendLsn {
+		t._pendLsn = end.Lsn
 	}
 	return nil
 }
 
+// 确认丢弃的事务，此前推送的事务未确认时不确认，以免越过未处理的事务
+func (t *Replication) ackSkipped(end ReplicationMessage) error {
+	if t._pendLsn > t._ackLsn {
+		return nil
+	}
+	return t.ack(end)
+}
+
+// 保存进度并确认end事件的lsn
+func (t *Replication) ack(end ReplicationMessage) error {
+	checkpoint := Checkpoint{Lsn: end.Lsn, Xid: end.Xid}
+	if end.Tx != nil {
+		checkpoint.CommitTime = end.Tx.CommitTime
+	}
+	if err := t.saveCheckpoint(checkpoint); err != nil {
+		return err
+	}
+	return t.sendStatus(end.Lsn)
+}
+
 func (t *Replication) Close() {
 	if t._conn != nil {
 		t._conn.Close()
 	}
 }
 
+// Start 开始复制，阻塞直至出错或ctx取消
+// 设置Reconnect后断线时自动重连，从最后确认的lsn继续
 func (t *Replication) Start(ctx context.Context, dmlHandler ReplicationDMLHandler) (err error) {
+	if t.reconnect == nil {
+		// 再次调用Start时丢弃上次会话的状态
+		t._flushMsg = nil
+		t._pendLsn = 0
+		t.plugin.Reset()
+		t.set.Reset()
+		_, err = t.run(ctx, dmlHandler, true)
+		return
+	}
+	return t.supervise(ctx, dmlHandler)
+}
+
+// 单次复制会话，started表示已成功开始接收消息
+func (t *Replication) run(ctx context.Context, dmlHandler ReplicationDMLHandler, setup bool) (started bool, err error) {
 	conn, err := t.conn()
 	if err != nil {
 		return
 	}
 	defer conn.Close()
-	// create replica identity|publication|replication
-	if err = t.CreateReplication(); err != nil {
-		return fmt.Errorf("CreateReplication %v", err)
+	if setup {
+		// create replica identity|publication|replication
+		if err = t.CreateReplication(); err != nil {
+			return false, fmt.Errorf("CreateReplication %w", err)
+		}
+		if err = t.loadCheckpoint(); err != nil {
+			return false, err
+		}
 	}
-	// start replication slot
-	pluginArguments := t.pluginArgs("1", t.name)
-	if err = conn.StartReplication(t.name, 0, -1, pluginArguments...); err != nil {
-		return fmt.Errorf("StartReplication %v", err)
+	// domain/enum类型信息，仅pgoutput按类型OID解码列值
+	if t.plugin.Name() == "pgoutput" {
+		if err = t.loadTypes(); err != nil {
+			return false, err
+		}
+	}
+	// start replication slot，首次为0即从复制槽的confirmed_flush_lsn开始
+	pluginArguments := t.plugin.StartArgs(t)
+	if err = conn.StartReplication(t.name, t._ackLsn, -1, pluginArguments...); err != nil {
+		return false, fmt.Errorf("StartReplication %w", err)
 	}
+	started = true
 	// ready notify
-	dmlHandler(ReplicationMessage{EventType: EventType_READY})
+	if setup {
+		dmlHandler(ReplicationMessage{EventType: EventType_READY})
+	}
 	// round read
 	waitTimeout := 10 * time.Second
 	for {
@@ -174,22 +472,22 @@ func (t *Replication) Start(ctx context.Context, dmlHandler ReplicationDMLHandle
 		wctx, cancel := context.WithTimeout(ctx, waitTimeout)
 		message, err = conn.WaitForReplicationMessage(wctx)
 		cancel()
-		if err == context.DeadlineExceeded {
+		if err == context.DeadlineExceeded && ctx.Err() == nil {
 			continue
 		}
 		if err != nil {
-			return fmt.Errorf("WaitForReplicationMessage: %s", err)
+			return started, fmt.Errorf("WaitForReplicationMessage: %w", err)
 		}
 		if message.WalMessage != nil {
 			if err = t.handle(message.WalMessage, dmlHandler); err != nil {
-				return err
+				return started, err
 			}
 		}
 		// 服务器心跳验证当前sub是否可用
 		// 不向master发送reply可能会导致连接EOF
 		if message.ServerHeartbeat != nil {
 			if message.ServerHeartbeat.ReplyRequested == 1 {
-				if err = t.SendStatusACK(0); err != nil {
+				if err = t.sendStatus(0); err != nil {
 					t.debug("replication", "ServerHeartbeat", err)
 				}
 			}
@@ -230,11 +528,11 @@ func (t *Replication) result(sql string) (res []map[string]interface{}, err erro
 	if err != nil {
 		return
 	}
+	defer rows.Close()
 	res = make([]map[string]interface{}, 0)
 	var values []interface{}
 	for rows.Next() {
 		values, err = rows.Values()
-		fmt.Println(values, rows.FieldDescriptions())
 		if err != nil {
 			return
 		}
@@ -244,13 +542,22 @@ func (t *Replication) result(sql string) (res []map[string]interface{}, err erro
 		}
 		res = append(res, item)
 	}
+	err = rows.Err()
 	return
 }
 
 // SendStatusACK
 // 向master发送lsn，即：WAL中使用者已经收到解码数据的最新位置
 // 详见：select * from pg_catalog.pg_replication_slots；结果中的confirmed_flush_lsn
+// 设置了CheckpointStore时先保存进度，保存失败不发送
 func (t *Replication) SendStatusACK(lsn uint64) error {
+	if err := t.saveCheckpoint(Checkpoint{Lsn: lsn}); err != nil {
+		return err
+	}
+	return t.sendStatus(lsn)
+}
+
+func (t *Replication) sendStatus(lsn uint64) error {
 	conn, err := t.conn()
 	if err != nil {
 		return err
@@ -264,22 +571,19 @@ func (t *Replication) SendStatusACK(lsn uint64) error {
 	}); err != nil {
 		return err
 	}
+	if lsn > t._ackLsn {
+		t._ackLsn = lsn
+	}
 	t.debug("sendStatus lsn:", lsn, pgx.FormatLSN(lsn))
 	return nil
 }
 
-func (t *Replication) pluginArgs(version, publication string) []string {
-	//} else if outputPlugin == "wal2json" {
-	//	pluginArguments = []string{"\"pretty-print\" 'true'"}
-	//}
-	return []string{fmt.Sprintf(`proto_version '%s'`, version), fmt.Sprintf(`publication_names '%s'`, publication)}
-}
-
 // CreateReplication 创建逻辑复制槽
 // 锁定起始lsn位置
+// 复制槽选项由输出插件决定，已存在的槽不会被修改
 func (t *Replication) CreateReplication() (err error) {
-	// create publication
-	return t.execEx(fmt.Sprintf("CREATE_REPLICATION_SLOT %s LOGICAL %s NOEXPORT_SNAPSHOT", t.name, "pgoutput"))
+	options := strings.Join(t.plugin.SlotOptions(t), " ")
+	return t.execEx(fmt.Sprintf("CREATE_REPLICATION_SLOT %s LOGICAL %s %s", t.name, t.plugin.Name(), options))
 }
 
 // DropReplication 移除复制槽
@@ -0,0 +1,437 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/pgtype"
+)
+
+// 不连接服务端的复制连接，记录确认的lsn
+type fakeConn struct {
+	acks     []uint64
+	closed   bool
+	startErr error //StartReplication返回的错误
+	waitErr  error //WaitForReplicationMessage返回的错误，nil时阻塞至ctx结束
+}
+
+func (c *fakeConn) IsAlive() bool { return !c.closed }
+func (c *fakeConn) Close() error  { c.closed = true; return nil }
+func (c *fakeConn) Exec(sql string, arguments ...interface{}) (pgx.CommandTag, error) {
+	return "", nil
+}
+func (c *fakeConn) Query(sql string, args ...interface{}) (*pgx.Rows, error) {
+	return nil, errors.New("query not supported")
+}
+func (c *fakeConn) StartReplication(slotName string, startLsn uint64, timeline int64, pluginArguments ...string) error {
+	return c.startErr
+}
+func (c *fakeConn) WaitForReplicationMessage(ctx context.Context) (*pgx.ReplicationMessage, error) {
+	if c.waitErr != nil {
+		return nil, c.waitErr
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+func (c *fakeConn) SendStandbyStatus(k *pgx.StandbyStatus) error {
+	c.acks = append(c.acks, k.WalFlushPosition)
+	return nil
+}
+
+// 使用fakeConn的Replication
+func testReplication() (*Replication, *fakeConn) {
+	conn := &fakeConn{}
+	r := NewReplication("test_slot", pgx.ConnConfig{})
+	r._conn = conn
+	return r, conn
+}
+
+// 依次处理pgoutput消息，第i条消息的wal位置为i+1
+func testHandle(t *testing.T, r *Replication, handler ReplicationDMLHandler, messages ...Message) {
+	t.Helper()
+	for i, src := range encodeAll(t, messages...) {
+		if err := r.handle(&pgx.WalMessage{WalStart: uint64(i + 1), WalData: src}, handler); err != nil {
+			t.Fatalf("message %d %T: %s", i, messages[i], err)
+		}
+	}
+}
+
+func TestHandleStreamInterleaved(t *testing.T) {
+	r, conn := testReplication()
+	r.Streaming()
+	rel := nullRelation(pgtype.TextOID)
+	rel.XID = 7
+	row := func(xid uint32) Insert {
+		return Insert{XID: xid, RelationID: 1, New: true, Row: []Tuple{{Flag: 't', Value: []byte("1")}, {Flag: 'n'}}}
+	}
+	type batch struct {
+		msgs  []ReplicationMessage
+		acked int //推送时已确认的次数
+	}
+	var batches []batch
+	testHandle(t, r, func(msgs ...ReplicationMessage) DMLHandlerStatus {
+		batches = append(batches, batch{msgs, len(conn.acks)})
+		return DMLHandlerStatusSuccess
+	},
+		StreamStart{XID: 7, FirstSegment: true},
+		rel,
+		row(7),
+		StreamStop{},
+		StreamStart{XID: 8, FirstSegment: true},
+		row(8),
+		row(9), //8的子事务
+		StreamStop{},
+		StreamStart{XID: 7},
+		row(7),
+		StreamStop{},
+		StreamAbort{XID: 8, SubXID: 9},
+		StreamCommit{XID: 7, LSN: 100, TransactionLSN: 120},
+		StreamCommit{XID: 8, LSN: 130, TransactionLSN: 140},
+	)
+	want := [][]struct {
+		event       EventType
+		xid, subXid uint32
+	}{
+		{{EventType_INSERT, 7, 7}, {EventType_STREAM_STOP, 7, 0}},
+		{{EventType_INSERT, 8, 8}, {EventType_INSERT, 8, 9}, {EventType_STREAM_STOP, 8, 0}},
+		{{EventType_INSERT, 7, 7}, {EventType_STREAM_STOP, 7, 0}},
+		{{EventType_STREAM_ABORT, 8, 9}},
+		{{EventType_STREAM_COMMIT, 7, 7}},
+		{{EventType_STREAM_COMMIT, 8, 8}},
+	}
+	if len(batches) != len(want) {
+		t.Fatalf("got %d batches, want %d", len(batches), len(want))
+	}
+	txs := map[uint32]*Transaction{}
+	for i, b := range batches {
+		if len(b.msgs) != len(want[i]) {
+			t.Errorf("batch %d: got %d messages, want %d", i, len(b.msgs), len(want[i]))
+			continue
+		}
+		for j, m := range b.msgs {
+			w := want[i][j]
+			if m.EventType != w.event || m.Xid != w.xid || m.SubXid != w.subXid {
+				t.Errorf("batch %d message %d: got %v xid %d subxid %d, want %v xid %d subxid %d",
+					i, j, m.EventType, m.Xid, m.SubXid, w.event, w.xid, w.subXid)
+			}
+			if m.Tx == nil || m.Tx.Xid != w.xid {
+				t.Errorf("batch %d message %d: tx %+v, want xid %d", i, j, m.Tx, w.xid)
+				continue
+			}
+			// 同一流式事务的各块共享事务信息
+			if tx, ok := txs[w.xid]; ok && tx != m.Tx {
+				t.Errorf("batch %d message %d: tx of xid %d changed", i, j, w.xid)
+			}
+			txs[w.xid] = m.Tx
+		}
+		if b.acked != 0 && i < 5 {
+			t.Errorf("batch %d: acknowledged before STREAM_COMMIT", i)
+		}
+	}
+	if tx := txs[7]; tx.CommitLsn != 100 || tx.EndLsn != 120 {
+		t.Errorf("xid 7: commit lsn %d end lsn %d", tx.CommitLsn, tx.EndLsn)
+	}
+	if len(conn.acks) != 2 || conn.acks[0] != 13 || conn.acks[1] != 14 {
+		t.Errorf("acks %v, want [13 14]", conn.acks)
+	}
+}
+
+func TestHandleTwoPhase(t *testing.T) {
+	r, conn := testReplication()
+	r.TwoPhase()
+	row := func(xid uint32) Insert {
+		return Insert{XID: xid, RelationID: 1, New: true, Row: []Tuple{{Flag: 't', Value: []byte("1")}, {Flag: 'n'}}}
+	}
+	var batches [][]ReplicationMessage
+	testHandle(t, r, func(msgs ...ReplicationMessage) DMLHandlerStatus {
+		batches = append(batches, msgs)
+		return DMLHandlerStatusSuccess
+	},
+		BeginPrepare{LSN: 100, EndLSN: 120, XID: 7, GID: "g1"},
+		nullRelation(pgtype.TextOID),
+		row(0),
+		Prepare{LSN: 100, EndLSN: 120, XID: 7, GID: "g1"},
+		CommitPrepared{LSN: 130, EndLSN: 140, XID: 7, GID: "g1"},
+		BeginPrepare{LSN: 150, EndLSN: 160, XID: 8, GID: "g2"},
+		row(0),
+		Prepare{LSN: 150, EndLSN: 160, XID: 8, GID: "g2"},
+		RollbackPrepared{PrepareEndLSN: 160, EndLSN: 170, XID: 8, GID: "g2"},
+		StreamStart{XID: 9, FirstSegment: true},
+		row(9),
+		StreamStop{},
+		StreamPrepare{LSN: 180, EndLSN: 190, XID: 9, GID: "g3"},
+		CommitPrepared{LSN: 200, EndLSN: 210, XID: 9, GID: "g3"},
+	)
+	want := [][]struct {
+		event EventType
+		xid   uint32
+		gid   string
+	}{
+		{{EventType_INSERT, 7, ""}, {EventType_PREPARE, 7, "g1"}},
+		{{EventType_COMMIT_PREPARED, 7, "g1"}},
+		{{EventType_INSERT, 8, ""}, {EventType_PREPARE, 8, "g2"}},
+		{{EventType_ROLLBACK_PREPARED, 8, "g2"}},
+		{{EventType_INSERT, 9, ""}, {EventType_STREAM_STOP, 9, ""}},
+		{{EventType_STREAM_PREPARE, 9, "g3"}},
+		{{EventType_COMMIT_PREPARED, 9, "g3"}},
+	}
+	if len(batches) != len(want) {
+		t.Fatalf("got %d batches, want %d", len(batches), len(want))
+	}
+	for i, msgs := range batches {
+		if len(msgs) != len(want[i]) {
+			t.Errorf("batch %d: got %d messages, want %d", i, len(msgs), len(want[i]))
+			continue
+		}
+		for j, m := range msgs {
+			w := want[i][j]
+			if m.EventType != w.event || m.Xid != w.xid || m.Gid != w.gid || m.Tx == nil || m.Tx.Xid != w.xid {
+				t.Errorf("batch %d message %d: got %v xid %d gid %q tx %+v, want %v xid %d gid %q",
+					i, j, m.EventType, m.Xid, m.Gid, m.Tx, w.event, w.xid, w.gid)
+			}
+		}
+	}
+	if tx := batches[0][1].Tx; tx.CommitLsn != 100 || tx.EndLsn != 120 {
+		t.Errorf("prepare: commit lsn %d end lsn %d", tx.CommitLsn, tx.EndLsn)
+	}
+	// PREPARE与COMMIT/ROLLBACK PREPARED均确认，流式事务块不确认
+	wantAcks := []uint64{4, 5, 8, 9, 13, 14}
+	if len(conn.acks) != len(wantAcks) {
+		t.Fatalf("acks %v, want %v", conn.acks, wantAcks)
+	}
+	for i, lsn := range wantAcks {
+		if conn.acks[i] != lsn {
+			t.Errorf("acks %v, want %v", conn.acks, wantAcks)
+			break
+		}
+	}
+}
+
+func TestHandleSkipOrigin(t *testing.T) {
+	insert := Insert{RelationID: 1, New: true, Row: []Tuple{{Flag: 't', Value: []byte("1")}, {Flag: 'n'}}}
+	status := DMLHandlerStatusSuccess
+	var delivered []EventType
+	handler := func(msgs ...ReplicationMessage) DMLHandlerStatus {
+		for _, m := range msgs {
+			delivered = append(delivered, m.EventType)
+		}
+		return status
+	}
+	local := []Message{Begin{LSN: 100, XID: 1}, insert, Commit{LSN: 100, TransactionLSN: 120}}
+	looped := []Message{Begin{LSN: 200, XID: 2}, Origin{LSN: 90, Name: "node1"}, insert, Commit{LSN: 200, TransactionLSN: 220}}
+	seq := func(groups ...[]Message) (res []Message) {
+		res = append(res, nullRelation(pgtype.TextOID))
+		for _, g := range groups {
+			res = append(res, g...)
+		}
+		return
+	}
+
+	// 丢弃的事务不推送，此前无未确认的事务时确认其lsn
+	r, conn := testReplication()
+	r.SkipOrigins("node1")
+	testHandle(t, r, handler, seq(looped)...)
+	if len(delivered) != 0 {
+		t.Errorf("skipped transaction delivered: %v", delivered)
+	}
+	if len(conn.acks) != 1 || conn.acks[0] != 5 {
+		t.Errorf("acks %v, want [5]", conn.acks)
+	}
+
+	// handler未确认此前的事务时，丢弃的事务不确认，以免越过未处理的事务
+	r, conn = testReplication()
+	r.SkipOrigins("node1")
+	status = DMLHandlerStatusContinue
+	testHandle(t, r, handler, seq(local, looped)...)
+	if len(conn.acks) != 0 {
+		t.Errorf("acks %v while transaction at 4 is pending", conn.acks)
+	}
+	// 此后的事务确认后恢复确认丢弃的事务
+	status = DMLHandlerStatusSuccess
+	delivered = nil
+	testHandle(t, r, handler, seq(local, looped)...)
+	if len(conn.acks) != 2 || conn.acks[0] != 4 || conn.acks[1] != 8 {
+		t.Errorf("acks %v, want [4 8]", conn.acks)
+	}
+	if len(delivered) != 2 || delivered[0] != EventType_INSERT || delivered[1] != EventType_COMMIT {
+		t.Errorf("delivered %v, want local transaction only", delivered)
+	}
+}
+
+func TestHandleSkipOriginPrepared(t *testing.T) {
+	insert := Insert{RelationID: 1, New: true, Row: []Tuple{{Flag: 't', Value: []byte("1")}, {Flag: 'n'}}}
+	for _, end := range []Message{
+		CommitPrepared{LSN: 130, EndLSN: 140, XID: 7, GID: "g1"},
+		RollbackPrepared{PrepareEndLSN: 120, EndLSN: 140, XID: 7, GID: "g1"},
+	} {
+		r, conn := testReplication()
+		r.TwoPhase().SkipOrigins("node1")
+		var delivered []ReplicationMessage
+		testHandle(t, r, func(msgs ...ReplicationMessage) DMLHandlerStatus {
+			delivered = append(delivered, msgs...)
+			return DMLHandlerStatusSuccess
+		},
+			nullRelation(pgtype.TextOID),
+			BeginPrepare{LSN: 100, EndLSN: 120, XID: 7, GID: "g1"},
+			Origin{LSN: 90, Name: "node1"},
+			insert,
+			Prepare{LSN: 100, EndLSN: 120, XID: 7, GID: "g1"},
+			// COMMIT/ROLLBACK PREPARED不带复制源，按gid丢弃
+			end,
+		)
+		if len(delivered) != 0 {
+			t.Errorf("%T: skipped transaction delivered: %+v", end, delivered)
+		}
+		if len(conn.acks) != 2 || conn.acks[0] != 5 || conn.acks[1] != 6 {
+			t.Errorf("%T: acks %v, want [5 6]", end, conn.acks)
+		}
+		if len(r._skipGid) != 0 {
+			t.Errorf("%T: skipped gids %v not released", end, r._skipGid)
+		}
+	}
+}
+
+// 丢弃或回滚的事务中的结构变更仍推送
+func TestHandleSchemaChangeDropped(t *testing.T) {
+	rel := nullRelation(pgtype.TextOID)
+	altered := rel
+	altered.Columns = append(append([]Column{}, rel.Columns...), Column{Name: "x", Type: pgtype.Int8OID})
+	insert := Insert{RelationID: 1, New: true, Row: []Tuple{{Flag: 't', Value: []byte("1")}, {Flag: 'n'}, {Flag: 't', Value: []byte("2")}}}
+	streamed := func(m Message) Message {
+		switch v := m.(type) {
+		case Relation:
+			v.XID = 7
+			return v
+		case Insert:
+			v.XID = 7
+			return v
+		}
+		return m
+	}
+	tests := []struct {
+		name     string
+		r        *Replication
+		messages []Message
+	}{
+		{"skipped origin", NewReplication("test_slot", pgx.ConnConfig{}).SkipOrigins("node1"), []Message{
+			Begin{LSN: 100, XID: 7}, Origin{LSN: 90, Name: "node1"}, altered, insert, Commit{LSN: 100, TransactionLSN: 120},
+		}},
+		{"stream abort", NewReplication("test_slot", pgx.ConnConfig{}).Streaming(), []Message{
+			StreamStart{XID: 7, FirstSegment: true}, streamed(altered), streamed(insert), StreamStop{}, StreamAbort{XID: 7, SubXID: 7},
+		}},
+		{"rollback prepared", NewReplication("test_slot", pgx.ConnConfig{}).TwoPhase().SkipOrigins("node1"), []Message{
+			BeginPrepare{LSN: 100, EndLSN: 120, XID: 7, GID: "g1"}, Origin{LSN: 90, Name: "node1"}, altered, insert,
+			Prepare{LSN: 100, EndLSN: 120, XID: 7, GID: "g1"}, RollbackPrepared{PrepareEndLSN: 120, EndLSN: 140, XID: 7, GID: "g1"},
+		}},
+	}
+	for _, tt := range tests {
+		tt.r._conn = &fakeConn{}
+		var changes []ReplicationMessage
+		handler := func(msgs ...ReplicationMessage) DMLHandlerStatus {
+			for _, m := range msgs {
+				if m.EventType == EventType_SCHEMA_CHANGE {
+					changes = append(changes, m)
+				}
+			}
+			return DMLHandlerStatusSuccess
+		}
+		testHandle(t, tt.r, handler, rel)
+		testHandle(t, tt.r, handler, tt.messages...)
+		if len(changes) != 1 || len(changes[0].Schema.Added) != 1 || changes[0].Schema.Added[0].Name != "x" {
+			t.Errorf("%s: got schema changes %+v, want one adding x", tt.name, changes)
+		}
+	}
+}
+
+func TestValueEqual(t *testing.T) {
+	ts := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	arr := func(text string) *pgtype.Int4Array {
+		a := &pgtype.Int4Array{}
+		_ = a.DecodeText(nil, []byte(text))
+		return a
+	}
+	num := func(text string) *pgtype.Numeric {
+		n := &pgtype.Numeric{}
+		_ = n.DecodeText(nil, []byte(text))
+		return n
+	}
+	tests := []struct {
+		name string
+		a, b interface{}
+		want bool
+	}{
+		{"nil", nil, nil, true},
+		{"nil and value", nil, int32(0), false},
+		{"int", int32(1), int32(1), true},
+		{"int type", int32(1), int64(1), false},
+		{"float NaN", math.NaN(), math.NaN(), true},
+		{"float32 NaN", float32(math.NaN()), float32(math.NaN()), true},
+		{"float NaN and number", math.NaN(), float64(1), false},
+		{"float", 1.5, 1.5, true},
+		{"time zones", ts, ts.In(time.FixedZone("CST", 8*3600)), true},
+		{"time", ts, ts.Add(time.Second), false},
+		{"time and text", ts, ts.Format(time.RFC3339), false},
+		{"bytea", []byte{1, 2}, []byte{1, 2}, true},
+		{"bytea changed", []byte{1, 2}, []byte{1, 3}, false},
+		{"jsonb", map[string]interface{}{"a": 1.0, "b": []interface{}{"x"}}, map[string]interface{}{"b": []interface{}{"x"}, "a": 1.0}, true},
+		{"jsonb changed", map[string]interface{}{"a": 1.0}, map[string]interface{}{"a": 2.0}, false},
+		{"normalized array", []interface{}{int64(1), int64(2)}, []interface{}{int64(1), int64(2)}, true},
+		{"normalized array changed", []interface{}{int64(1), int64(2)}, []interface{}{int64(2), int64(1)}, false},
+		{"pgtype array", arr("{1,2}"), arr("{1,2}"), true},
+		{"pgtype array changed", arr("{1,2}"), arr("{1,2,3}"), false},
+		{"numeric", num("1.5"), num("1.5"), true},
+		{"numeric NaN", num("NaN"), num("NaN"), true},
+		{"numeric scale", num("1.5"), num("1.50"), false},
+	}
+	for _, tt := range tests {
+		if got := valueEqual(tt.a, tt.b); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDiffColumns(t *testing.T) {
+	ts := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	oldBody := map[string]interface{}{
+		"id":      int32(1),
+		"f":       math.NaN(),
+		"at":      ts,
+		"meta":    map[string]interface{}{"a": 1.0},
+		"payload": []byte{1},
+		"tags":    []interface{}{"a"},
+		"dropped": "x",
+	}
+	body := map[string]interface{}{
+		"id":      int32(1),
+		"f":       math.NaN(),
+		"at":      ts.In(time.FixedZone("CST", 8*3600)),
+		"meta":    map[string]interface{}{"a": 2.0},
+		"payload": []byte{1},
+		"tags":    []interface{}{"a", "b"},
+		"added":   nil,
+	}
+	columns, changes := diffColumns(body, oldBody)
+	// 仅新行中存在的列视为变更，仅旧行中存在的列忽略
+	want := map[string]ColumnChange{
+		"meta":  {Old: map[string]interface{}{"a": 1.0}, New: map[string]interface{}{"a": 2.0}},
+		"tags":  {Old: []interface{}{"a"}, New: []interface{}{"a", "b"}},
+		"added": {Old: nil, New: nil},
+	}
+	if !reflect.DeepEqual(columns, []string{"added", "meta", "tags"}) {
+		t.Errorf("columns %v", columns)
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes %#v, want %#v", changes, want)
+	}
+	if columns, changes = diffColumns(body, body); columns != nil || changes != nil {
+		t.Errorf("unchanged row: columns %v, changes %v", columns, changes)
+	}
+	if columns, changes = diffColumns(body, nil); columns != nil || changes != nil {
+		t.Errorf("without old row: columns %v, changes %v", columns, changes)
+	}
+}
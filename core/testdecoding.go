@@ -0,0 +1,408 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/pgtype"
+)
+
+// TestDecoding test_decoding插件，每条wal消息为一行文本，多用于调试及无pgoutput的旧版本
+// 不使用发布流，列值按类型名以文本格式解码，与pgoutput结果一致
+//
+//	BEGIN 529
+//	table public.data: INSERT: id[integer]:1 data[text]:'it''s' tags[text[]]:'{a,b}' flags[bit(3)]:B'101' note[text]:null
+//	table public.data: UPDATE: old-key: id[integer]:1 new-tuple: id[integer]:2 data[text]:unchanged-toast-datum
+//	COMMIT 529 (at 2023-01-02 03:04:05.123456+08)
+type TestDecoding struct {
+	tx *Transaction
+}
+
+// test_decoding输出的类型名(format_type，去除类型修饰)对应的OID
+var testDecodingTypes = map[string]uint32{
+	"boolean":                       pgtype.BoolOID,
+	"bytea":                         pgtype.ByteaOID,
+	`"char"`:                        pgtype.CharOID,
+	"name":                          pgtype.NameOID,
+	"bigint":                        pgtype.Int8OID,
+	"smallint":                      pgtype.Int2OID,
+	"integer":                       pgtype.Int4OID,
+	"text":                          pgtype.TextOID,
+	"oid":                           pgtype.OIDOID,
+	"tid":                           pgtype.TIDOID,
+	"xid":                           pgtype.XIDOID,
+	"cid":                           pgtype.CIDOID,
+	"json":                          pgtype.JSONOID,
+	"cidr":                          pgtype.CIDROID,
+	"real":                          pgtype.Float4OID,
+	"double precision":              pgtype.Float8OID,
+	"inet":                          pgtype.InetOID,
+	"aclitem":                       pgtype.ACLItemOID,
+	"character":                     pgtype.BPCharOID,
+	"character varying":             pgtype.VarcharOID,
+	"date":                          pgtype.DateOID,
+	"timestamp without time zone":   pgtype.TimestampOID,
+	"timestamp with time zone":      pgtype.TimestamptzOID,
+	"numeric":                       pgtype.NumericOID,
+	"uuid":                          pgtype.UUIDOID,
+	"bit":                           bitOID,
+	"bit varying":                   varbitOID,
+	"money":                         moneyOID,
+	"jsonb":                         pgtype.JSONBOID,
+	"boolean[]":                     pgtype.BoolArrayOID,
+	"bytea[]":                       pgtype.ByteaArrayOID,
+	"smallint[]":                    pgtype.Int2ArrayOID,
+	"integer[]":                     pgtype.Int4ArrayOID,
+	"bigint[]":                      pgtype.Int8ArrayOID,
+	"text[]":                        pgtype.TextArrayOID,
+	"character[]":                   pgtype.BPCharArrayOID,
+	"character varying[]":           pgtype.VarcharArrayOID,
+	"real[]":                        pgtype.Float4ArrayOID,
+	"double precision[]":            pgtype.Float8ArrayOID,
+	"aclitem[]":                     pgtype.ACLItemArrayOID,
+	"inet[]":                        pgtype.InetArrayOID,
+	"cidr[]":                        pgtype.CIDRArrayOID,
+	"date[]":                        pgtype.DateArrayOID,
+	"timestamp without time zone[]": pgtype.TimestampArrayOID,
+	"timestamp with time zone[]":    pgtype.TimestamptzArrayOID,
+	"uuid[]":                        pgtype.UUIDArrayOID,
+}
+
+func (p *TestDecoding) Name() string {
+	return "test_decoding"
+}
+
+func (p *TestDecoding) Reset() {
+	p.tx = nil
+}
+
+func (p *TestDecoding) SlotOptions(t *Replication) []string {
+	return []string{"NOEXPORT_SNAPSHOT"}
+}
+
+func (p *TestDecoding) StartArgs(t *Replication) []string {
+	return []string{`"include-xids" '1'`, `"include-timestamp" '1'`, `"skip-empty-xacts" '1'`}
+}
+
+func (p *TestDecoding) Decode(t *Replication, message *pgx.WalMessage) (res []ReplicationMessage, err error) {
+	line := string(message.WalData)
+	switch {
+	case strings.HasPrefix(line, "BEGIN"):
+		p.tx = &Transaction{BeginLsn: message.WalStart}
+		if xid, er := strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, "BEGIN")), 10, 32); er == nil {
+			p.tx.Xid = uint32(xid)
+		}
+	case strings.HasPrefix(line, "COMMIT"):
+		tx := p.tx
+		if tx == nil {
+			tx = &Transaction{}
+		}
+		// COMMIT 529 (at 2023-01-02 03:04:05.123456+08)
+		rest := strings.TrimSpace(strings.TrimPrefix(line, "COMMIT"))
+		if i := strings.Index(rest, " (at "); i >= 0 {
+			if ts, er := parseTimestamptz(strings.TrimSuffix(rest[i+5:], ")")); er == nil {
+				tx.CommitTime = ts
+			}
+			rest = rest[:i]
+		}
+		if xid, er := strconv.ParseUint(rest, 10, 32); er == nil {
+			tx.Xid = uint32(xid)
+		}
+		tx.CommitLsn = message.WalStart
+		p.tx = nil
+		res = append(res, ReplicationMessage{EventType: EventType_COMMIT, Xid: tx.Xid, Lsn: message.WalStart, Tx: tx})
+	case strings.HasPrefix(line, "table "):
+		var changes []ReplicationMessage
+		if changes, err = p.change(line, t.normalize); err != nil {
+			return nil, fmt.Errorf("invalid test_decoding message: %s: %q", err, line)
+		}
+		for _, m := range changes {
+			res = append(res, withTransaction(m, p.tx, message.WalStart))
+		}
+	case strings.HasPrefix(line, "message: "):
+		var m ReplicationMessage
+		if m, err = p.message(line); err != nil {
+			return nil, fmt.Errorf("invalid test_decoding message: %s: %q", err, line)
+		}
+		m.Lsn = message.WalStart
+		if m.Transactional {
+			m = withTransaction(m, p.tx, message.WalStart)
+		}
+		res = append(res, m)
+	default:
+		return nil, fmt.Errorf("invalid test_decoding message: %q", line)
+	}
+	return
+}
+
+// table public.data: INSERT: id[integer]:1 data[text]:'1'
+// table public.a, public.b: TRUNCATE: cascade，每个表一条消息
+func (p *TestDecoding) change(line string, normalize bool) (res []ReplicationMessage, err error) {
+	line = strings.TrimPrefix(line, "table ")
+	i := strings.Index(line, ": ")
+	if i < 0 {
+		return nil, fmt.Errorf("missing table name")
+	}
+	tables := testDecodingTables(line[:i])
+	line = line[i+2:]
+	i = strings.Index(line, ":")
+	if i < 0 {
+		return nil, fmt.Errorf("missing action")
+	}
+	action, line := line[:i], strings.TrimPrefix(line[i+1:], " ")
+	var m ReplicationMessage
+	switch action {
+	case "INSERT":
+		m.EventType = EventType_INSERT
+	case "UPDATE":
+		m.EventType = EventType_UPDATE
+	case "DELETE":
+		m.EventType = EventType_DELETE
+	case "TRUNCATE":
+		for _, table := range tables {
+			res = append(res, ReplicationMessage{
+				EventType:       EventType_TRUNCATE,
+				SchemaName:      table[0],
+				TableName:       table[1],
+				Cascade:         strings.Contains(line, "cascade"),
+				RestartIdentity: strings.Contains(line, "restart_seq"),
+			})
+		}
+		return
+	default:
+		return nil, fmt.Errorf("unknown action %q", action)
+	}
+	if len(tables) != 1 {
+		return nil, fmt.Errorf("%s of %d tables", action, len(tables))
+	}
+	m.SchemaName, m.TableName = tables[0][0], tables[0][1]
+	if err = testDecodingRow(&m, line, normalize); err != nil {
+		return nil, err
+	}
+	return []ReplicationMessage{m}, nil
+}
+
+// 解析INSERT/UPDATE/DELETE的列值
+func testDecodingRow(m *ReplicationMessage, line string, normalize bool) (err error) {
+	if line == "(no-tuple-data)" {
+		return
+	}
+	var row, oldRow map[string]interface{}
+	var unchanged []string
+	if strings.HasPrefix(line, "old-key: ") {
+		i := strings.Index(line, " new-tuple: ")
+		if i < 0 {
+			return fmt.Errorf("missing new-tuple")
+		}
+		if oldRow, _, err = testDecodingTuple(line[len("old-key: "):i], normalize); err != nil {
+			return
+		}
+		line = line[i+len(" new-tuple: "):]
+	}
+	if row, unchanged, err = testDecodingTuple(line, normalize); err != nil {
+		return
+	}
+	// 用旧行补全未变更的TOAST列
+	for _, name := range unchanged {
+		if value, ok := oldRow[name]; ok {
+			row[name] = value
+			continue
+		}
+		m.Unchanged = append(m.Unchanged, name)
+	}
+	if m.EventType == EventType_UPDATE {
+		m.Columns, m.Changes = diffColumns(row, oldRow)
+	}
+	m.Body = row
+	// DELETE的行即为旧行，无法区分复制标识列与整行
+	if m.EventType == EventType_DELETE {
+		m.OldBody = make(map[string]interface{}, len(row))
+		for name, value := range row {
+			m.OldBody[name] = value
+		}
+	} else if oldRow != nil {
+		m.OldBody = oldRow
+		m.OldKeyOnly = len(oldRow) < len(row)+len(m.Unchanged)
+	}
+	return
+}
+
+// message: transactional: 1 prefix: test, sz: 4 content:test
+func (p *TestDecoding) message(line string) (m ReplicationMessage, err error) {
+	m.EventType = EventType_MESSAGE
+	line = strings.TrimPrefix(line, "message: transactional: ")
+	m.Transactional = strings.HasPrefix(line, "1")
+	i := strings.Index(line, "prefix: ")
+	j := strings.Index(line, ", sz: ")
+	k := strings.Index(line, " content:")
+	if i < 0 || j < i || k < j {
+		return m, fmt.Errorf("malformed message")
+	}
+	m.Prefix = line[i+len("prefix: ") : j]
+	m.Content = []byte(line[k+len(" content:"):])
+	return
+}
+
+// public.data 或 "My Schema"."My Table"，TRUNCATE时为逗号分隔的多个表
+// 返回[schema, table]列表
+func testDecodingTables(s string) (tables [][2]string) {
+	c := &testDecodingCursor{s: s}
+	for !c.eof() {
+		var table [2]string
+		table[1] = c.ident(".,")
+		if c.peek() == '.' {
+			c.i++
+			table[0], table[1] = table[1], c.ident(",")
+		}
+		tables = append(tables, table)
+		if c.peek() == ',' {
+			c.i++
+		}
+		if c.peek() == ' ' {
+			c.i++
+		}
+	}
+	return
+}
+
+// 解析 name[type]:value 列表，返回列值与未变更的TOAST列
+func testDecodingTuple(s string, normalize bool) (values map[string]interface{}, unchanged []string, err error) {
+	values = make(map[string]interface{})
+	c := &testDecodingCursor{s: s}
+	for !c.eof() {
+		name := c.ident("[")
+		if c.peek() != '[' {
+			return nil, nil, fmt.Errorf("missing type of column %s", name)
+		}
+		c.i++
+		end := strings.Index(c.s[c.i:], "]:")
+		if end < 0 {
+			return nil, nil, fmt.Errorf("missing value of column %s", name)
+		}
+		typ := c.s[c.i : c.i+end]
+		c.i += end + 2
+		var text string
+		var quoted bool
+		// bit/varbit输出为B'101'
+		if c.peek() == 'B' && strings.HasPrefix(c.s[c.i+1:], "'") {
+			c.i++
+		}
+		if c.peek() == '\'' {
+			if text, err = c.quoted(); err != nil {
+				return nil, nil, fmt.Errorf("column %s: %s", name, err)
+			}
+			quoted = true
+		} else {
+			text = c.until(" ")
+		}
+		switch {
+		case !quoted && text == "null":
+			values[name] = nil
+		case !quoted && text == "unchanged-toast-datum":
+			unchanged = append(unchanged, name)
+		default:
+			if values[name], err = testDecodingValue(typ, text, normalize); err != nil {
+				return nil, nil, fmt.Errorf("column %s: %s", name, err)
+			}
+		}
+		if c.peek() == ' ' {
+			c.i++
+		}
+	}
+	return
+}
+
+// 按类型名以文本格式解码，未知类型保留字符串，normalize时按Normalize转换
+func testDecodingValue(typ, text string, normalize bool) (interface{}, error) {
+	// 去除类型修饰，如 character varying(255)[]
+	if i := strings.IndexByte(typ, '('); i >= 0 {
+		if j := strings.IndexByte(typ[i:], ')'); j >= 0 {
+			typ = typ[:i] + typ[i+j+1:]
+		}
+	}
+	oid, ok := testDecodingTypes[typ]
+	if !ok {
+		return text, nil
+	}
+	// boolean输出为true/false
+	if oid == pgtype.BoolOID && len(text) > 0 {
+		text = text[:1]
+	}
+	decoder := Column{Type: oid}.Decoder()
+	var value pgtype.Value = decoder
+	if special, ok := numericSpecial(decoder, Tuple{Flag: 't', Value: []byte(text)}); ok {
+		value = special
+	} else if err := decoder.DecodeText(nil, []byte(text)); err != nil {
+		return nil, err
+	}
+	if normalize {
+		return Normalize(value), nil
+	}
+	return value.Get(), nil
+}
+
+type testDecodingCursor struct {
+	s string
+	i int
+}
+
+func (c *testDecodingCursor) eof() bool {
+	return c.i >= len(c.s)
+}
+
+func (c *testDecodingCursor) peek() byte {
+	if c.eof() {
+		return 0
+	}
+	return c.s[c.i]
+}
+
+// 读取至seps中任一字符(不含)，seps为空时读取至末尾
+func (c *testDecodingCursor) until(seps string) string {
+	start := c.i
+	for !c.eof() && strings.IndexByte(seps, c.s[c.i]) < 0 {
+		c.i++
+	}
+	return c.s[start:c.i]
+}
+
+// 标识符，可为双引号包裹(""转义)
+func (c *testDecodingCursor) ident(seps string) string {
+	if c.peek() != '"' {
+		return c.until(seps)
+	}
+	var b strings.Builder
+	c.i++
+	for !c.eof() {
+		ch := c.s[c.i]
+		c.i++
+		if ch == '"' {
+			if c.peek() != '"' {
+				break
+			}
+			c.i++
+		}
+		b.WriteByte(ch)
+	}
+	return b.String()
+}
+
+// 单引号包裹的字符串(”转义)
+func (c *testDecodingCursor) quoted() (string, error) {
+	var b strings.Builder
+	c.i++
+	for !c.eof() {
+		ch := c.s[c.i]
+		c.i++
+		if ch == '\'' {
+			if c.peek() != '\'' {
+				return b.String(), nil
+			}
+			c.i++
+		}
+		b.WriteByte(ch)
+	}
+	return "", fmt.Errorf("unterminated string")
+}
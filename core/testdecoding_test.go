@@ -0,0 +1,161 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/pgtype"
+)
+
+// 依次解码wal消息，r为nil时使用默认配置
+func testDecode(t *testing.T, r *Replication, p OutputPlugin, data ...[]byte) []ReplicationMessage {
+	t.Helper()
+	if r == nil {
+		r = NewReplication("test_slot", pgx.ConnConfig{})
+	}
+	r.Plugin(p)
+	var res []ReplicationMessage
+	for i, src := range data {
+		msgs, err := p.Decode(r, &pgx.WalMessage{WalStart: uint64(i + 1), WalData: src})
+		if err != nil {
+			t.Fatalf("%q: %s", src, err)
+		}
+		res = append(res, msgs...)
+	}
+	return res
+}
+
+func testDecodingLines(lines ...string) [][]byte {
+	data := make([][]byte, len(lines))
+	for i, line := range lines {
+		data[i] = []byte(line)
+	}
+	return data
+}
+
+func TestTestDecodingTruncate(t *testing.T) {
+	res := testDecode(t, nil, &TestDecoding{}, testDecodingLines(
+		"BEGIN 529",
+		`table public.a, "My Schema"."b, c", public.d: TRUNCATE: restart_seq cascade`,
+		"COMMIT 529 (at 2023-01-02 03:04:05.123456+08)",
+	)...)
+	want := [][2]string{{"public", "a"}, {"My Schema", "b, c"}, {"public", "d"}}
+	if len(res) != len(want)+1 {
+		t.Fatalf("got %d messages, want %d", len(res), len(want)+1)
+	}
+	for i, table := range want {
+		m := res[i]
+		if m.EventType != EventType_TRUNCATE || m.SchemaName != table[0] || m.TableName != table[1] {
+			t.Errorf("message %d: %v %s.%s, want TRUNCATE %s.%s", i, m.EventType, m.SchemaName, m.TableName, table[0], table[1])
+		}
+		if !m.Cascade || !m.RestartIdentity || m.Xid != 529 {
+			t.Errorf("message %d: cascade %v, restart identity %v, xid %d", i, m.Cascade, m.RestartIdentity, m.Xid)
+		}
+	}
+	commit := res[len(want)]
+	if ts := time.Date(2023, 1, 1, 19, 4, 5, 123456000, time.UTC); !commit.Tx.CommitTime.Equal(ts) {
+		t.Errorf("commit time %s, want %s", commit.Tx.CommitTime, ts)
+	}
+}
+
+func TestTestDecodingMultiTableInsert(t *testing.T) {
+	p := &TestDecoding{}
+	r := NewReplication("test_slot", pgx.ConnConfig{}).Plugin(p)
+	line := "table public.a, public.b: INSERT: id[integer]:1"
+	if _, err := p.Decode(r, &pgx.WalMessage{WalData: []byte(line)}); err == nil {
+		t.Fatal("expected error for INSERT of several tables")
+	}
+}
+
+func TestTestDecodingNull(t *testing.T) {
+	res := testDecode(t, nil, &TestDecoding{}, testDecodingLines(
+		"table public.t: INSERT: id[integer]:1 a[text]:null b[numeric]:null c[integer[]]:null d[geometry]:null e[text]:'null'",
+		"table public.t: DELETE: id[integer]:1 a[text]:null",
+	)...)
+	for _, name := range []string{"a", "b", "c", "d"} {
+		if value, ok := res[0].Body[name]; !ok || value != nil {
+			t.Errorf("insert %s: got %#v, want nil", name, value)
+		}
+	}
+	if value := res[0].Body["e"]; value != "null" {
+		t.Errorf("insert e: got %#v, want quoted null text", value)
+	}
+	if value, ok := res[1].Body["a"]; !ok || value != nil {
+		t.Errorf("delete a: got %#v, want nil", value)
+	}
+}
+
+func TestTestDecodingNumericNaN(t *testing.T) {
+	res := testDecode(t, nil, &TestDecoding{}, testDecodingLines(
+		"table public.t: INSERT: a[numeric]:NaN b[numeric(10,2)]:12.50 c[oid]:16384 d[money]:'$1,000.00'",
+	)...)
+	if value := res[0].Body["a"]; value != "NaN" {
+		t.Errorf("a: got %#v, want NaN", value)
+	}
+	if value := res[0].Body["c"]; value != uint32(16384) {
+		t.Errorf("c: got %#v, want 16384", value)
+	}
+	if value := res[0].Body["d"]; value != int64(100000) {
+		t.Errorf("d: got %#v, want 100000 cents", value)
+	}
+}
+
+func TestTestDecodingQuoted(t *testing.T) {
+	res := testDecode(t, nil, &TestDecoding{}, testDecodingLines(
+		`table public.t: INSERT: a[text]:'it''s' b[text]:'x y]:z' c[character varying(10)]:'''' d[text[]]:'{a,"b c","d''e"}' `+
+			`e[bit(3)]:B'101' f[bit varying(8)]:B'' "g h"[text]:'null' i[integer]:-1`,
+	)...)
+	body := res[0].Body
+	for name, want := range map[string]string{"a": "it's", "b": "x y]:z", "c": "'", "g h": "null"} {
+		if body[name] != want {
+			t.Errorf("%s: got %#v, want %q", name, body[name], want)
+		}
+	}
+	array, ok := body["d"].(*pgtype.TextArray)
+	if !ok || len(array.Elements) != 3 || array.Elements[1].String != "b c" || array.Elements[2].String != "d'e" {
+		t.Errorf("d: got %#v", body["d"])
+	}
+	for name, want := range map[string]string{"e": "101", "f": ""} {
+		bits, ok := body[name].(*pgtype.Varbit)
+		if !ok {
+			t.Errorf("%s: got %#v, want bit string", name, body[name])
+			continue
+		}
+		if buf, _ := bits.EncodeText(nil, nil); string(buf) != want {
+			t.Errorf("%s: got %q, want %q", name, buf, want)
+		}
+	}
+	if body["i"] != int32(-1) {
+		t.Errorf("i: got %#v", body["i"])
+	}
+}
+
+// Normalize对test_decoding的列值生效，与pgoutput的结果一致
+func TestTestDecodingNormalize(t *testing.T) {
+	r := NewReplication("test_slot", pgx.ConnConfig{}).Normalize()
+	res := testDecode(t, r, &TestDecoding{}, testDecodingLines(
+		`table public.t: INSERT: id[integer]:1 n[numeric]:12.50 nan[numeric]:NaN f[double precision]:NaN ts[timestamp with time zone]:'2023-01-02 03:04:05+08' b[bytea]:'\x0102' j[jsonb]:'{"a": 1}' arr[integer[]]:'{1,2}' m[money]:'$1,234.56' g[geometry]:'01'`,
+	)...)
+	want := map[string]interface{}{
+		"id":  int64(1),
+		"n":   "12.50",
+		"nan": "NaN",
+		"f":   "NaN",
+		"ts":  "2023-01-01T19:04:05Z",
+		"b":   "AQI=",
+		"j":   map[string]interface{}{"a": float64(1)},
+		"arr": []interface{}{int64(1), int64(2)},
+		"m":   int64(123456),
+		"g":   "01",
+	}
+	if !reflect.DeepEqual(res[0].Body, want) {
+		t.Errorf("got %#v, want %#v", res[0].Body, want)
+	}
+	// 未开启时为pgtype的Get值
+	res = testDecode(t, nil, &TestDecoding{}, testDecodingLines(`table public.t: INSERT: n[numeric]:12.50 id[integer]:1`)...)
+	if _, ok := res[0].Body["n"].(*pgtype.Numeric); !ok || res[0].Body["id"] != int32(1) {
+		t.Errorf("got %#v", res[0].Body)
+	}
+}
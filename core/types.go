@@ -0,0 +1,255 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/jackc/pgx/pgtype"
+)
+
+// 自定义类型分类，见pg_type.typtype
+const (
+	TypeKindBase      byte = 'b'
+	TypeKindComposite byte = 'c'
+	TypeKindDomain    byte = 'd'
+	TypeKindEnum      byte = 'e'
+	TypeKindPseudo    byte = 'p'
+	TypeKindRange     byte = 'r'
+)
+
+// TypeInfo 自定义类型信息，来自'Y' Type消息及pg_type查询
+type TypeInfo struct {
+	OID       uint32
+	Namespace string
+	Name      string
+	Kind      byte     //TypeKind*，仅查询pg_type后可知
+	BaseType  uint32   //domain的基础类型
+	Labels    []string //enum的全部标签(按enumsortorder)
+}
+
+// FullName schema.name
+func (ti TypeInfo) FullName() string {
+	if ti.Namespace == "" {
+		return ti.Name
+	}
+	return ti.Namespace + "." + ti.Name
+}
+
+// TypeDecoder 用户注册的类型解码器构造函数，每次解码返回新的实例
+type TypeDecoder func() DecoderValue
+
+// Converter 列值转换器，对解码后的非NULL列值做后处理，返回值作为Body中的列值
+type Converter func(value pgtype.Value) (interface{}, error)
+
+// 查询全部domain与enum类型
+// 枚举值用子查询聚合，避免GROUP BY(PG13及以下不支持按主键推导函数依赖的列)
+// 复制连接仅能解码int4/name/oid/text/varchar列，结果列只使用这些类型
+const typesSQL = `SELECT t.oid, n.nspname AS namespace, t.typname AS name, t.typtype::text AS kind, t.typbasetype AS base,
+coalesce((SELECT json_agg(e.enumlabel ORDER BY e.enumsortorder) FROM pg_enum e WHERE e.enumtypid = t.oid), '[]')::text AS labels
+FROM pg_type t JOIN pg_namespace n ON n.oid = t.typnamespace
+WHERE t.typtype IN ('d', 'e')`
+
+// AddType 记录'Y' Type消息，保留已查询到的分类信息
+func (rs *RelationSet) AddType(t Type) {
+	ti := rs.types[t.ID]
+	ti.OID, ti.Namespace, ti.Name = t.ID, t.Namespace, t.Name
+	rs.types[t.ID] = ti
+}
+
+// AddTypeInfo 记录完整的类型信息
+func (rs *RelationSet) AddTypeInfo(ti TypeInfo) {
+	rs.types[ti.OID] = ti
+}
+
+// TypeInfo 获取类型信息
+func (rs *RelationSet) TypeInfo(oid uint32) (TypeInfo, bool) {
+	ti, ok := rs.types[oid]
+	return ti, ok
+}
+
+// RegisterDecoder 按类型名注册解码器，name可为"schema.name"或"name"
+func (rs *RelationSet) RegisterDecoder(name string, decoder TypeDecoder) {
+	rs.decoders[name] = decoder
+}
+
+// RegisterOIDDecoder 按类型OID注册解码器，可覆盖内置类型
+func (rs *RelationSet) RegisterOIDDecoder(oid uint32, decoder TypeDecoder) {
+	rs.oidDecoders[oid] = decoder
+}
+
+// RegisterConverter 注册列值转换器，key可为"schema.table.column"或类型名("schema.name"或"name")
+// 内置类型使用pg_type中的类型名，如"jsonb"、"pg_catalog.int4"、数组"_text"
+func (rs *RelationSet) RegisterConverter(key string, converter Converter) {
+	rs.converters[key] = converter
+}
+
+// RegisterOIDConverter 按类型OID注册列值转换器
+func (rs *RelationSet) RegisterOIDConverter(oid uint32, converter Converter) {
+	rs.oidConverters[oid] = converter
+}
+
+// 获取列的转换器
+// 优先级：schema.table.column > OID > schema.name > name，无则返回nil
+func (rs *RelationSet) converter(rel Relation, col Column) Converter {
+	if len(rs.converters) == 0 && len(rs.oidConverters) == 0 {
+		return nil
+	}
+	if converter, ok := rs.converters[rel.Namespace+"."+rel.Name+"."+col.Name]; ok {
+		return converter
+	}
+	if converter, ok := rs.oidConverters[col.Type]; ok {
+		return converter
+	}
+	ti, ok := rs.types[col.Type]
+	if !ok {
+		// 内置类型无'Y' Type消息，按pg_catalog中的类型名匹配
+		name, ok := builtinTypeNames[col.Type]
+		if !ok {
+			return nil
+		}
+		ti = TypeInfo{OID: col.Type, Namespace: "pg_catalog", Name: name}
+	}
+	if converter, ok := rs.converters[ti.FullName()]; ok {
+		return converter
+	}
+	if converter, ok := rs.converters[ti.Name]; ok {
+		return converter
+	}
+	return nil
+}
+
+// 内置类型的pg_type.typname，覆盖Column.Decoder支持的类型
+var builtinTypeNames = map[uint32]string{
+	pgtype.ACLItemArrayOID:     "_aclitem",
+	pgtype.ACLItemOID:          "aclitem",
+	bitOID:                     "bit",
+	pgtype.BoolArrayOID:        "_bool",
+	pgtype.BoolOID:             "bool",
+	boxOID:                     "box",
+	pgtype.BPCharArrayOID:      "_bpchar",
+	pgtype.BPCharOID:           "bpchar",
+	pgtype.ByteaArrayOID:       "_bytea",
+	pgtype.ByteaOID:            "bytea",
+	pgtype.CIDOID:              "cid",
+	pgtype.CIDRArrayOID:        "_cidr",
+	pgtype.CIDROID:             "cidr",
+	pgtype.CharOID:             "char",
+	circleOID:                  "circle",
+	pgtype.DateArrayOID:        "_date",
+	pgtype.DateOID:             "date",
+	daterangeOID:               "daterange",
+	pgtype.Float4ArrayOID:      "_float4",
+	pgtype.Float4OID:           "float4",
+	pgtype.Float8ArrayOID:      "_float8",
+	pgtype.Float8OID:           "float8",
+	pgtype.InetArrayOID:        "_inet",
+	pgtype.InetOID:             "inet",
+	pgtype.Int2ArrayOID:        "_int2",
+	pgtype.Int2OID:             "int2",
+	pgtype.Int4ArrayOID:        "_int4",
+	pgtype.Int4OID:             "int4",
+	int4rangeOID:               "int4range",
+	pgtype.Int8ArrayOID:        "_int8",
+	pgtype.Int8OID:             "int8",
+	int8rangeOID:               "int8range",
+	intervalOID:                "interval",
+	pgtype.JSONBOID:            "jsonb",
+	pgtype.JSONOID:             "json",
+	lineOID:                    "line",
+	lsegOID:                    "lseg",
+	macaddrArrayOID:            "_macaddr",
+	macaddrOID:                 "macaddr",
+	moneyOID:                   "money",
+	pgtype.NameOID:             "name",
+	numericArrayOID:            "_numeric",
+	pgtype.NumericOID:          "numeric",
+	numrangeOID:                "numrange",
+	pgtype.OIDOID:              "oid",
+	pathOID:                    "path",
+	pointOID:                   "point",
+	polygonOID:                 "polygon",
+	pgtype.RecordOID:           "record",
+	pgtype.TIDOID:              "tid",
+	pgtype.TextArrayOID:        "_text",
+	pgtype.TextOID:             "text",
+	timeOID:                    "time",
+	timetzOID:                  "timetz",
+	pgtype.TimestampArrayOID:   "_timestamp",
+	pgtype.TimestampOID:        "timestamp",
+	pgtype.TimestamptzArrayOID: "_timestamptz",
+	pgtype.TimestamptzOID:      "timestamptz",
+	tsrangeOID:                 "tsrange",
+	tstzrangeOID:               "tstzrange",
+	pgtype.UUIDArrayOID:        "_uuid",
+	pgtype.UUIDOID:             "uuid",
+	pgtype.UnknownOID:          "unknown",
+	varbitOID:                  "varbit",
+	pgtype.VarcharArrayOID:     "_varchar",
+	pgtype.VarcharOID:          "varchar",
+	pgtype.XIDOID:              "xid",
+}
+
+// 获取列的解码器
+// 优先级：用户注册(OID > schema.name > name) > domain基础类型 > enum文本 > 内置类型
+func (rs *RelationSet) decoder(oid uint32) DecoderValue {
+	if decoder, ok := rs.oidDecoders[oid]; ok {
+		return decoder()
+	}
+	// 防止domain循环引用
+	for depth := 0; depth < 16; depth++ {
+		ti, ok := rs.types[oid]
+		if !ok {
+			break
+		}
+		if decoder, ok := rs.decoders[ti.FullName()]; ok {
+			return decoder()
+		}
+		if decoder, ok := rs.decoders[ti.Name]; ok {
+			return decoder()
+		}
+		switch ti.Kind {
+		case TypeKindDomain:
+			oid = ti.BaseType
+			continue
+		case TypeKindEnum:
+			return &pgtype.Text{}
+		}
+		break
+	}
+	return Column{Type: oid}.Decoder()
+}
+
+// 从pg_type加载domain与enum类型
+func (t *Replication) loadTypes() error {
+	res, err := t.result(typesSQL)
+	if err != nil {
+		return fmt.Errorf("load types: %w", err)
+	}
+	for _, item := range res {
+		ti, err := typeInfo(item)
+		if err != nil {
+			return fmt.Errorf("load types: %w", err)
+		}
+		t.set.AddTypeInfo(ti)
+	}
+	return nil
+}
+
+// typesSQL结果中的一行，oid列为uint32
+func typeInfo(item map[string]interface{}) (ti TypeInfo, err error) {
+	var ok bool
+	if ti.OID, ok = item["oid"].(uint32); !ok {
+		return ti, fmt.Errorf("invalid oid %#v", item["oid"])
+	}
+	ti.BaseType, _ = item["base"].(uint32)
+	ti.Namespace, _ = item["namespace"].(string)
+	ti.Name, _ = item["name"].(string)
+	if kind, _ := item["kind"].(string); kind != "" {
+		ti.Kind = kind[0]
+	}
+	labels, _ := item["labels"].(string)
+	if err = json.Unmarshal([]byte(labels), &ti.Labels); err != nil {
+		return ti, fmt.Errorf("%s labels %s", ti.FullName(), err)
+	}
+	return
+}
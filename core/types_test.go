@@ -0,0 +1,151 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/pgtype"
+)
+
+// 按复制连接的类型信息(pgx minimalConnInfo)解码typesSQL的结果行
+func TestTypeInfoMinimalConnInfo(t *testing.T) {
+	ci := pgtype.NewConnInfo()
+	ci.InitializeDataTypes(map[string]pgtype.OID{
+		"int4":    pgtype.Int4OID,
+		"name":    pgtype.NameOID,
+		"oid":     pgtype.OIDOID,
+		"text":    pgtype.TextOID,
+		"varchar": pgtype.VarcharOID,
+	})
+	if strings.Count(typesSQL, "::") != strings.Count(typesSQL, "::text") {
+		t.Fatalf("typesSQL casts to a type the replication connection cannot decode:\n%s", typesSQL)
+	}
+	tests := []struct {
+		row  [][3]string //列名、结果类型、文本值
+		want TypeInfo
+	}{
+		{
+			[][3]string{{"oid", "oid", "16385"}, {"namespace", "name", "public"}, {"name", "name", "mood"},
+				{"kind", "text", "e"}, {"base", "oid", "0"}, {"labels", "text", `["sad", "ok"]`}},
+			TypeInfo{OID: 16385, Namespace: "public", Name: "mood", Kind: TypeKindEnum, Labels: []string{"sad", "ok"}},
+		},
+		{
+			[][3]string{{"oid", "oid", "4294967295"}, {"namespace", "name", "information_schema"}, {"name", "name", "cardinal_number"},
+				{"kind", "text", "d"}, {"base", "oid", "23"}, {"labels", "text", "[]"}},
+			TypeInfo{OID: 4294967295, Namespace: "information_schema", Name: "cardinal_number", Kind: TypeKindDomain, BaseType: 23, Labels: []string{}},
+		},
+	}
+	for _, tt := range tests {
+		item := make(map[string]interface{})
+		for _, col := range tt.row {
+			dt, ok := ci.DataTypeForName(col[1])
+			if !ok {
+				t.Fatalf("column %s: unknown type %s", col[0], col[1])
+			}
+			value := dt.Value.(pgtype.TextDecoder)
+			if err := value.DecodeText(ci, []byte(col[2])); err != nil {
+				t.Fatalf("column %s: %s", col[0], err)
+			}
+			item[col[0]] = dt.Value.Get()
+		}
+		ti, err := typeInfo(item)
+		if err != nil {
+			t.Errorf("%s: %s", tt.want.FullName(), err)
+			continue
+		}
+		if ti.OID != tt.want.OID || ti.FullName() != tt.want.FullName() || ti.Kind != tt.want.Kind ||
+			ti.BaseType != tt.want.BaseType || strings.Join(ti.Labels, ",") != strings.Join(tt.want.Labels, ",") {
+			t.Errorf("got %+v, want %+v", ti, tt.want)
+		}
+	}
+	if _, err := typeInfo(map[string]interface{}{"oid": int64(1), "labels": "[]"}); err == nil {
+		t.Error("expected error for oid of wrong type")
+	}
+}
+
+// 转换器按列名、OID、schema.name、name的优先级匹配，内置类型按pg_type中的类型名匹配
+func TestConverterPriority(t *testing.T) {
+	rs := NewRelationSet()
+	rs.AddType(Type{ID: 16385, Namespace: "public", Name: "mood"})
+	rs.Add(Relation{ID: 1, Namespace: "public", Name: "t", Columns: []Column{
+		{Name: "a", Type: pgtype.TextOID},
+		{Name: "b", Type: pgtype.TextOID},
+		{Name: "c", Type: pgtype.JSONBOID},
+		{Name: "d", Type: pgtype.Int4OID},
+		{Name: "e", Type: 16385},
+		{Name: "f", Type: pgtype.TextArrayOID},
+		{Name: "g", Type: pgtype.BoolOID},
+	}})
+	tag := func(name string) Converter {
+		return func(value pgtype.Value) (interface{}, error) { return name, nil }
+	}
+	rs.RegisterConverter("public.t.a", tag("column"))
+	rs.RegisterConverter("text", tag("text"))
+	rs.RegisterConverter("jsonb", tag("jsonb"))
+	rs.RegisterConverter("pg_catalog.int4", tag("pg_catalog.int4"))
+	rs.RegisterConverter("int4", tag("int4"))
+	rs.RegisterConverter("public.mood", tag("public.mood"))
+	rs.RegisterConverter("mood", tag("mood"))
+	rs.RegisterConverter("_text", tag("_text"))
+	rs.RegisterOIDConverter(pgtype.TextArrayOID, tag("oid"))
+	row := []Tuple{
+		{Flag: 't', Value: []byte("a")},
+		{Flag: 't', Value: []byte("b")},
+		{Flag: 't', Value: []byte(`{"k":1}`)},
+		{Flag: 't', Value: []byte("1")},
+		{Flag: 't', Value: []byte("ok")},
+		{Flag: 't', Value: []byte("{x}")},
+		{Flag: 'n'},
+	}
+	values, err := rs.Values(1, row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"a": "column",
+		"b": "text",
+		"c": "jsonb",
+		"d": "pg_catalog.int4",
+		"e": "public.mood",
+		"f": "oid",
+	}
+	for name, result := range want {
+		converted, ok := values[name].(Converted)
+		if !ok {
+			t.Errorf("%s: got %T, want Converted", name, values[name])
+			continue
+		}
+		if converted.Result != result {
+			t.Errorf("%s: converted by %v, want %v", name, converted.Result, result)
+		}
+	}
+	// NULL不转换，未注册的类型保持原值
+	if _, ok := values["g"].(Null); !ok {
+		t.Errorf("g: got %T, want Null", values["g"])
+	}
+	rs.RegisterConverter("public.t.g", func(value pgtype.Value) (interface{}, error) {
+		return nil, fmt.Errorf("failed")
+	})
+	row[6] = Tuple{Flag: 't', Value: []byte("t")}
+	if _, err = rs.Values(1, row); err == nil {
+		t.Error("expected converter error")
+	}
+}
+
+// 按OID注册的解码器覆盖内置类型
+func TestRegisterTypeOID(t *testing.T) {
+	r := NewReplication("test_slot", pgx.ConnConfig{}).RegisterTypeOID(pgtype.Int4OID, func() DecoderValue { return &pgtype.Text{} })
+	r.set.Add(Relation{ID: 1, Columns: []Column{{Name: "v", Type: pgtype.Int4OID}}})
+	values, err := r.set.Values(1, []Tuple{{Flag: 't', Value: []byte("42")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := values["v"].Get(); got != "42" {
+		t.Errorf("got %#v, want text", got)
+	}
+	if got := Normalize(values["v"]); got != "42" {
+		t.Errorf("normalized %#v", got)
+	}
+}
@@ -2,21 +2,98 @@ package core
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/jackc/pgx/pgtype"
 )
 
+// 二进制解码所需的类型信息(record等复合类型)
+var connInfo = pgtype.NewConnInfo()
+
 type RelationSet struct {
 	// TODO: Add mutex
-	relations map[uint32]Relation
+	relations     map[uint32]Relation
+	previous      map[uint32]Relation //Reset前的Relation，作为新会话中比较结构变更的基准
+	types         map[uint32]TypeInfo
+	decoders      map[string]TypeDecoder
+	oidDecoders   map[uint32]TypeDecoder
+	converters    map[string]Converter
+	oidConverters map[uint32]Converter
 }
 
 func NewRelationSet() *RelationSet {
-	return &RelationSet{relations: map[uint32]Relation{}}
+	return &RelationSet{
+		relations:     map[uint32]Relation{},
+		previous:      map[uint32]Relation{},
+		types:         map[uint32]TypeInfo{},
+		decoders:      map[string]TypeDecoder{},
+		oidDecoders:   map[uint32]TypeDecoder{},
+		converters:    map[string]Converter{},
+		oidConverters: map[uint32]Converter{},
+	}
+}
+
+// Reset 清空缓存的Relation，新会话中服务端会重新发送Relation消息
+// 清空前的Relation保留为基准，断线期间的ALTER TABLE在重新收到Relation时仍产生结构变更
+// 类型信息及用户注册的解码器、转换器保留
+func (rs *RelationSet) Reset() {
+	for id, r := range rs.relations {
+		rs.previous[id] = r
+	}
+	rs.relations = map[uint32]Relation{}
 }
 
-func (rs *RelationSet) Add(r Relation) {
+// Add 缓存Relation，与已缓存的(或Reset前的)不同时(ALTER TABLE后重新发送)返回结构变更
+func (rs *RelationSet) Add(r Relation) *SchemaChange {
+	old, ok := rs.relations[r.ID]
+	if !ok {
+		old, ok = rs.previous[r.ID]
+		delete(rs.previous, r.ID)
+	}
 	rs.relations[r.ID] = r
+	if !ok {
+		return nil
+	}
+	return diffRelation(old, r)
+}
+
+// 比较新旧Relation，无变更时返回nil
+func diffRelation(old, r Relation) *SchemaChange {
+	change := &SchemaChange{OldReplica: old.Replica, Replica: r.Replica}
+	if old.Namespace != r.Namespace || old.Name != r.Name {
+		change.OldSchemaName, change.OldTableName = old.Namespace, old.Name
+	}
+	oldColumns := make(map[string]Column, len(old.Columns))
+	for _, col := range old.Columns {
+		oldColumns[col.Name] = col
+		if col.Key {
+			change.OldKeys = append(change.OldKeys, col.Name)
+		}
+	}
+	columns := make(map[string]bool, len(r.Columns))
+	for _, col := range r.Columns {
+		columns[col.Name] = true
+		if col.Key {
+			change.Keys = append(change.Keys, col.Name)
+		}
+		oldCol, ok := oldColumns[col.Name]
+		if !ok {
+			change.Added = append(change.Added, col)
+		} else if oldCol.Type != col.Type || oldCol.Mode != col.Mode {
+			change.Retyped = append(change.Retyped, ColumnRetype{Name: col.Name, OldType: oldCol.Type, OldMode: oldCol.Mode, Type: col.Type, Mode: col.Mode})
+		}
+	}
+	for _, col := range old.Columns {
+		if !columns[col.Name] {
+			change.Dropped = append(change.Dropped, col)
+		}
+	}
+	change.KeyChanged = strings.Join(change.OldKeys, ",") != strings.Join(change.Keys, ",")
+	if change.OldTableName == "" && len(change.Added) == 0 && len(change.Dropped) == 0 && len(change.Retyped) == 0 &&
+		!change.KeyChanged && change.OldReplica == change.Replica {
+		return nil
+	}
+	return change
 }
 
 func (rs *RelationSet) Assist(id uint32) (schema, table string) {
@@ -26,6 +103,28 @@ func (rs *RelationSet) Assist(id uint32) (schema, table string) {
 	return
 }
 
+// Null NULL列值，Get()恒为nil，保留列类型的解码器
+type Null struct {
+	DecoderValue
+}
+
+func (Null) Get() interface{} {
+	return nil
+}
+
+// Converted 经转换器处理的列值，Get()返回转换结果，其余方法使用解码后的原值
+type Converted struct {
+	pgtype.Value
+	Result interface{}
+}
+
+func (c Converted) Get() interface{} {
+	return c.Result
+}
+
+// Values 按Relation列解码行数据，列与Relation一一对应(未变更的TOAST列除外)，NULL为Null
+// 二进制格式下无内置解码器的类型(Column.Decoder为Unknown)为pgtype.GenericBinary，保留原始字节
+// 非NULL列值经注册的转换器处理后为Converted
 func (rs *RelationSet) Values(id uint32, row []Tuple) (values map[string]pgtype.Value, err error) {
 	values = map[string]pgtype.Value{}
 	rel, ok := rs.relations[id]
@@ -33,9 +132,45 @@ func (rs *RelationSet) Values(id uint32, row []Tuple) (values map[string]pgtype.
 		return nil, fmt.Errorf("no relation for %d", id)
 	}
 	// assert same number of row and columns
+	if len(row) != len(rel.Columns) {
+		return nil, fmt.Errorf("relation %d has %d columns, got %d tuples", id, len(rel.Columns), len(row))
+	}
 	for i, tuple := range row {
 		col := rel.Columns[i]
-		decoder := col.Decoder()
+		// 未变更的TOAST列没有值
+		if tuple.Flag == 'u' {
+			continue
+		}
+		decoder := rs.decoder(col.Type)
+		if tuple.Flag == 'n' {
+			_ = decoder.DecodeText(nil, nil)
+			values[col.Name] = Null{decoder}
+			continue
+		}
+		if value, ok := numericSpecial(decoder, tuple); ok {
+			values[col.Name] = value
+			continue
+		}
+		if tuple.Flag == 'b' {
+			// Unknown的DecodeBinary等同DecodeText，会将二进制内容当作文本，改为保留原始字节
+			if _, unknown := decoder.(*pgtype.Unknown); unknown {
+				raw := &pgtype.GenericBinary{}
+				_ = raw.DecodeBinary(connInfo, tuple.Value)
+				values[col.Name] = raw
+				continue
+			}
+			binaryDecoder, ok := decoder.(pgtype.BinaryDecoder)
+			if !ok {
+				err = fmt.Errorf("error decoding tuple %d: binary format not supported by the decoder registered for type %d", i, col.Type)
+				return
+			}
+			if err = binaryDecoder.DecodeBinary(connInfo, tuple.Value); err != nil {
+				err = fmt.Errorf("error decoding binary tuple %d: %s", i, err)
+				return
+			}
+			values[col.Name] = decoder
+			continue
+		}
 		// TODO: Pass in connection?
 		if err = decoder.DecodeText(nil, tuple.Value); err != nil {
 			err = fmt.Errorf("error decoding tuple %d: %s", i, err)
@@ -43,21 +178,107 @@ func (rs *RelationSet) Values(id uint32, row []Tuple) (values map[string]pgtype.
 		}
 		values[col.Name] = decoder
 	}
+	for i, col := range rel.Columns {
+		value, ok := values[col.Name]
+		if !ok {
+			continue
+		}
+		if _, null := value.(Null); null {
+			continue
+		}
+		if converter := rs.converter(rel, col); converter != nil {
+			var result interface{}
+			if result, err = converter(value); err != nil {
+				err = fmt.Errorf("error converting tuple %d: %s", i, err)
+				return
+			}
+			values[col.Name] = Converted{Value: value, Result: result}
+		}
+	}
 	return
 }
 
+// Keys 仅保留复制标识(主键)列
+func (rs *RelationSet) Keys(id uint32, values map[string]pgtype.Value) map[string]pgtype.Value {
+	rel, ok := rs.relations[id]
+	if !ok {
+		return values
+	}
+	keys := make(map[string]pgtype.Value)
+	for _, col := range rel.Columns {
+		if v, ok := values[col.Name]; ok && col.Key {
+			keys[col.Name] = v
+		}
+	}
+	return keys
+}
+
+// Restore 用旧行(REPLICA IDENTITY FULL)补全row中未变更的TOAST列('u')
+// 返回无法补全的列名
+func (rs *RelationSet) Restore(id uint32, row, oldRow []Tuple) (unchanged []string) {
+	rel, ok := rs.relations[id]
+	if !ok {
+		return nil
+	}
+	for i, tuple := range row {
+		if tuple.Flag != 'u' || i >= len(rel.Columns) {
+			continue
+		}
+		if i < len(oldRow) && (oldRow[i].Flag == 't' || oldRow[i].Flag == 'b') {
+			row[i] = oldRow[i]
+			continue
+		}
+		unchanged = append(unchanged, rel.Columns[i].Name)
+	}
+	return
+}
+
+// pgtype未定义常量的内置类型OID，见pg_type.dat
+const (
+	pointOID        = 600
+	lsegOID         = 601
+	pathOID         = 602
+	boxOID          = 603
+	polygonOID      = 604
+	lineOID         = 628
+	circleOID       = 718
+	moneyOID        = 790
+	macaddrOID      = 829
+	macaddrArrayOID = 1040
+	timeOID         = 1083
+	intervalOID     = 1186
+	numericArrayOID = 1231
+	timetzOID       = 1266
+	bitOID          = 1560
+	varbitOID       = 1562
+	int4rangeOID    = 3904
+	numrangeOID     = 3906
+	tsrangeOID      = 3908
+	tstzrangeOID    = 3910
+	daterangeOID    = 3912
+	int8rangeOID    = 3926
+)
+
 func (c Column) Decoder() DecoderValue {
 	switch c.Type {
 	case pgtype.ACLItemArrayOID:
 		return &pgtype.ACLItemArray{}
 	case pgtype.ACLItemOID:
 		return &pgtype.ACLItem{}
+	case bitOID:
+		return &pgtype.Bit{}
 	case pgtype.BoolArrayOID:
 		return &pgtype.BoolArray{}
 	case pgtype.BoolOID:
 		return &pgtype.Bool{}
+	case boxOID:
+		return &pgtype.Box{}
+	case pgtype.BPCharArrayOID:
+		return &pgtype.BPCharArray{}
+	case pgtype.BPCharOID:
+		return &pgtype.BPChar{}
 	case pgtype.ByteaArrayOID:
-		return &pgtype.BoolArray{}
+		return &pgtype.ByteaArray{}
 	case pgtype.ByteaOID:
 		return &pgtype.Bytea{}
 	case pgtype.CIDOID:
@@ -69,10 +290,14 @@ func (c Column) Decoder() DecoderValue {
 	case pgtype.CharOID:
 		// Not all possible values of QChar are representable in the text format
 		return &pgtype.Unknown{}
+	case circleOID:
+		return &pgtype.Circle{}
 	case pgtype.DateArrayOID:
 		return &pgtype.DateArray{}
 	case pgtype.DateOID:
 		return &pgtype.Date{}
+	case daterangeOID:
+		return &pgtype.Daterange{}
 	case pgtype.Float4ArrayOID:
 		return &pgtype.Float4Array{}
 	case pgtype.Float4OID:
@@ -93,19 +318,46 @@ func (c Column) Decoder() DecoderValue {
 		return &pgtype.Int4Array{}
 	case pgtype.Int4OID:
 		return &pgtype.Int4{}
+	case int4rangeOID:
+		return &pgtype.Int4range{}
 	case pgtype.Int8ArrayOID:
 		return &pgtype.Int8Array{}
 	case pgtype.Int8OID:
 		return &pgtype.Int8{}
+	case int8rangeOID:
+		return &pgtype.Int8range{}
+	case intervalOID:
+		return &pgtype.Interval{}
 	case pgtype.JSONBOID:
 		return &pgtype.JSONB{}
 	case pgtype.JSONOID:
 		return &pgtype.JSON{}
+	case lineOID:
+		return &pgtype.Line{}
+	case lsegOID:
+		return &pgtype.Lseg{}
+	case macaddrArrayOID:
+		return &pgtype.MacaddrArray{}
+	case macaddrOID:
+		return &pgtype.Macaddr{}
+	case moneyOID:
+		return &Money{}
 	case pgtype.NameOID:
 		return &pgtype.Name{}
+	case numericArrayOID:
+		return &pgtype.NumericArray{}
+	case pgtype.NumericOID:
+		return &pgtype.Numeric{}
+	case numrangeOID:
+		return &pgtype.Numrange{}
 	case pgtype.OIDOID:
-		// pgtype.OID does not implement the value interface
-		return &pgtype.Unknown{}
+		return &pgtype.OIDValue{}
+	case pathOID:
+		return &pgtype.Path{}
+	case pointOID:
+		return &pgtype.Point{}
+	case polygonOID:
+		return &pgtype.Polygon{}
 	case pgtype.RecordOID:
 		// The text format output format for Records does not include type
 		// information and is therefore impossible to decode
@@ -116,6 +368,10 @@ func (c Column) Decoder() DecoderValue {
 		return &pgtype.TextArray{}
 	case pgtype.TextOID:
 		return &pgtype.Text{}
+	case timeOID:
+		return &Time{}
+	case timetzOID:
+		return &Timetz{}
 	case pgtype.TimestampArrayOID:
 		return &pgtype.TimestampArray{}
 	case pgtype.TimestampOID:
@@ -124,10 +380,18 @@ func (c Column) Decoder() DecoderValue {
 		return &pgtype.TimestamptzArray{}
 	case pgtype.TimestamptzOID:
 		return &pgtype.Timestamptz{}
+	case tsrangeOID:
+		return &pgtype.Tsrange{}
+	case tstzrangeOID:
+		return &pgtype.Tstzrange{}
+	case pgtype.UUIDArrayOID:
+		return &pgtype.UUIDArray{}
 	case pgtype.UUIDOID:
 		return &pgtype.UUID{}
 	case pgtype.UnknownOID:
 		return &pgtype.Unknown{}
+	case varbitOID:
+		return &pgtype.Varbit{}
 	case pgtype.VarcharArrayOID:
 		return &pgtype.VarcharArray{}
 	case pgtype.VarcharOID:
@@ -0,0 +1,413 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/pgtype"
+)
+
+// Column.Decoder支持的类型，text为文本格式的值，want为Normalize的结果
+var decoderTests = []struct {
+	oid  uint32
+	text string
+	want interface{}
+}{
+	{pgtype.ACLItemArrayOID, `{postgres=arwdDxt/postgres}`, []interface{}{"postgres=arwdDxt/postgres"}},
+	{pgtype.ACLItemOID, `postgres=arwdDxt/postgres`, "postgres=arwdDxt/postgres"},
+	{bitOID, "101", "101"},
+	{pgtype.BoolArrayOID, "{t,f}", []interface{}{true, false}},
+	{pgtype.BoolOID, "t", true},
+	{boxOID, "(1,2),(0,0)", "(1,2),(0,0)"},
+	{pgtype.BPCharArrayOID, `{"a  "}`, []interface{}{"a  "}},
+	{pgtype.BPCharOID, "ab  ", "ab  "},
+	{pgtype.ByteaArrayOID, `{"\\x0102"}`, []interface{}{"AQI="}},
+	{pgtype.ByteaOID, `\x0102`, "AQI="},
+	{pgtype.CIDOID, "5", int64(5)},
+	{pgtype.CIDRArrayOID, "{10.0.0.0/8}", []interface{}{"10.0.0.0/8"}},
+	{pgtype.CIDROID, "10.0.0.0/8", "10.0.0.0/8"},
+	{pgtype.CharOID, "a", "a"},
+	{circleOID, "<(1,2),3>", "<(1,2),3>"},
+	{pgtype.DateArrayOID, "{2023-01-02,infinity}", []interface{}{"2023-01-02", "infinity"}},
+	{pgtype.DateOID, "2023-01-02", "2023-01-02"},
+	{daterangeOID, "[2023-01-01,2023-02-01)", "[2023-01-01,2023-02-01)"},
+	{pgtype.Float4ArrayOID, "{1.5}", []interface{}{1.5}},
+	{pgtype.Float4OID, "1.5", 1.5},
+	{pgtype.Float8ArrayOID, "{1.5,NaN}", []interface{}{1.5, "NaN"}},
+	{pgtype.Float8OID, "-Infinity", "-Infinity"},
+	{pgtype.InetArrayOID, "{192.168.0.1}", []interface{}{"192.168.0.1/32"}},
+	{pgtype.InetOID, "192.168.0.0/24", "192.168.0.0/24"},
+	{pgtype.Int2ArrayOID, "{1,-2}", []interface{}{int64(1), int64(-2)}},
+	{pgtype.Int2OID, "-2", int64(-2)},
+	{pgtype.Int4ArrayOID, "{1,NULL}", []interface{}{int64(1), nil}},
+	{pgtype.Int4OID, "7", int64(7)},
+	{int4rangeOID, "[1,5)", "[1,5)"},
+	{pgtype.Int8ArrayOID, "{{1,2},{3,4}}", []interface{}{[]interface{}{int64(1), int64(2)}, []interface{}{int64(3), int64(4)}}},
+	{pgtype.Int8OID, "9007199254740993", int64(9007199254740993)},
+	{int8rangeOID, "[1,5)", "[1,5)"},
+	{intervalOID, "1 day 02:00:00", "1 day 02:00:00.000000"},
+	{pgtype.JSONBOID, `{"a": 1}`, map[string]interface{}{"a": 1.0}},
+	{pgtype.JSONOID, `[1, "x"]`, []interface{}{1.0, "x"}},
+	{lineOID, "{1,2,3}", "{1,2,3}"},
+	{lsegOID, "[(0,0),(1,1)]", "(0,0),(1,1)"},
+	{macaddrArrayOID, "{08:00:2b:01:02:03}", []interface{}{"08:00:2b:01:02:03"}},
+	{macaddrOID, "08:00:2b:01:02:03", "08:00:2b:01:02:03"},
+	{moneyOID, "$1,000.00", int64(100000)},
+	{pgtype.NameOID, "n", "n"},
+	{numericArrayOID, "{1.50,-2}", []interface{}{"1.50", "-2"}},
+	{numericArrayOID, "{1.50,NaN,-Infinity}", []interface{}{"1.50", "NaN", "-Infinity"}},
+	{pgtype.NumericOID, "12.50", "12.50"},
+	{pgtype.NumericOID, "NaN", "NaN"},
+	{pgtype.NumericOID, "Infinity", "Infinity"},
+	{pgtype.NumericOID, "-Infinity", "-Infinity"},
+	{numrangeOID, "[1.5,2.50)", "[1.5,2.50)"},
+	{numrangeOID, "(,10]", "(,10]"},
+	{numrangeOID, "empty", "empty"},
+	{pgtype.OIDOID, "16384", int64(16384)},
+	{pathOID, "[(0,0),(1,1)]", "[(0,0),(1,1)]"},
+	{pointOID, "(1,2)", "(1,2)"},
+	{polygonOID, "((0,0),(1,1),(1,0))", "((0,0),(1,1),(1,0))"},
+	{pgtype.RecordOID, "(1,a)", "(1,a)"},
+	{pgtype.TIDOID, "(0,1)", "(0,1)"},
+	{pgtype.TextArrayOID, `{a,"b c"}`, []interface{}{"a", "b c"}},
+	{pgtype.TextOID, "hello", "hello"},
+	{timeOID, "04:05:06.789", "04:05:06.789"},
+	{timetzOID, "04:05:06+08", "04:05:06+08"},
+	{pgtype.TimestampArrayOID, `{"2023-01-02 03:04:05"}`, []interface{}{"2023-01-02T03:04:05Z"}},
+	{pgtype.TimestampOID, "2023-01-02 03:04:05.123456", "2023-01-02T03:04:05.123456Z"},
+	{pgtype.TimestamptzArrayOID, `{"2023-01-02 03:04:05+08"}`, []interface{}{"2023-01-01T19:04:05Z"}},
+	{pgtype.TimestamptzOID, "2023-01-02 03:04:05.123456+08", "2023-01-01T19:04:05.123456Z"},
+	{tsrangeOID, `["2023-01-01 00:00:00","2023-01-02 00:00:00")`, "[2023-01-01 00:00:00,2023-01-02 00:00:00)"},
+	{tstzrangeOID, `["2023-01-01 00:00:00+00","2023-01-02 00:00:00+00")`, "[2023-01-01 00:00:00Z,2023-01-02 00:00:00Z)"},
+	{pgtype.UUIDArrayOID, "{a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11}", []interface{}{"a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"}},
+	{pgtype.UUIDOID, "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11", "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"},
+	{pgtype.UnknownOID, "x", "x"},
+	{varbitOID, "10101", "10101"},
+	{pgtype.VarcharArrayOID, "{a,b}", []interface{}{"a", "b"}},
+	{pgtype.VarcharOID, "abc", "abc"},
+	{pgtype.XIDOID, "529", int64(529)},
+}
+
+func TestValuesText(t *testing.T) {
+	for _, tt := range decoderTests {
+		rs := NewRelationSet()
+		rs.Add(Relation{ID: 1, Columns: []Column{{Name: "v", Type: tt.oid}}})
+		values, err := rs.Values(1, []Tuple{{Flag: 't', Value: []byte(tt.text)}})
+		if err != nil {
+			t.Errorf("oid %d %q: %s", tt.oid, tt.text, err)
+			continue
+		}
+		if got := Normalize(values["v"]); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("oid %d %q: got %#v, want %#v", tt.oid, tt.text, got, tt.want)
+		}
+	}
+}
+
+// numeric的NaN/Infinity在二进制格式中的表示
+func TestValuesBinaryNumericSpecial(t *testing.T) {
+	special := func(sign uint16) []byte {
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint16(b[4:], sign)
+		return b
+	}
+	one := &pgtype.Numeric{}
+	_ = one.Set("1.5")
+	oneBinary, _ := one.EncodeBinary(nil, nil)
+	array := []byte{0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0x06, 0xa4, 0, 0, 0, 3, 0, 0, 0, 1}
+	for _, elem := range [][]byte{oneBinary, special(0xC000), nil} {
+		if elem == nil {
+			array = append(array, 0xff, 0xff, 0xff, 0xff)
+			continue
+		}
+		array = append(array, 0, 0, 0, byte(len(elem)))
+		array = append(array, elem...)
+	}
+	tests := []struct {
+		oid  uint32
+		src  []byte
+		want interface{}
+	}{
+		{pgtype.NumericOID, special(0xC000), "NaN"},
+		{pgtype.NumericOID, special(0xD000), "Infinity"},
+		{pgtype.NumericOID, special(0xF000), "-Infinity"},
+		{pgtype.NumericOID, oneBinary, "1.5"},
+		{numericArrayOID, array, []interface{}{"1.5", "NaN", nil}},
+	}
+	for _, tt := range tests {
+		rs := NewRelationSet()
+		rs.Add(Relation{ID: 1, Columns: []Column{{Name: "v", Type: tt.oid}}})
+		values, err := rs.Values(1, []Tuple{{Flag: 'b', Value: tt.src}})
+		if err != nil {
+			t.Errorf("oid %d %x: %s", tt.oid, tt.src, err)
+			continue
+		}
+		if got := Normalize(values["v"]); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("oid %d %x: got %#v, want %#v", tt.oid, tt.src, got, tt.want)
+		}
+	}
+}
+
+// 基准测试用的表：int8, timestamptz, numeric, float8[]
+func benchmarkRelation() (*RelationSet, []Tuple, []Tuple) {
+	rs := NewRelationSet()
+	rs.Add(Relation{ID: 1, Namespace: "public", Name: "t", Columns: []Column{
+		{Name: "id", Type: pgtype.Int8OID},
+		{Name: "created_at", Type: pgtype.TimestamptzOID},
+		{Name: "amount", Type: pgtype.NumericOID},
+		{Name: "scores", Type: pgtype.Float8ArrayOID},
+	}})
+	values := []pgtype.BinaryEncoder{
+		&pgtype.Int8{Int: 1234567890, Status: pgtype.Present},
+		&pgtype.Timestamptz{Time: time.Date(2023, 1, 2, 3, 4, 5, 123456000, time.UTC), Status: pgtype.Present},
+		&pgtype.Numeric{},
+		&pgtype.Float8Array{},
+	}
+	_ = values[2].(*pgtype.Numeric).Set("123456.789")
+	_ = values[3].(*pgtype.Float8Array).Set([]float64{1.5, 2.25, 3.125, 4.0625})
+	texts := []string{"1234567890", "2023-01-02 03:04:05.123456+00", "123456.789", "{1.5,2.25,3.125,4.0625}"}
+	ci := pgtype.NewConnInfo()
+	ci.InitializeDataTypes(map[string]pgtype.OID{"float8": pgtype.Float8OID})
+	textRow := make([]Tuple, len(values))
+	binaryRow := make([]Tuple, len(values))
+	for i, value := range values {
+		textRow[i] = Tuple{Flag: 't', Value: []byte(texts[i])}
+		buf, err := value.EncodeBinary(ci, nil)
+		if err != nil {
+			panic(err)
+		}
+		binaryRow[i] = Tuple{Flag: 'b', Value: buf}
+	}
+	return rs, textRow, binaryRow
+}
+
+func TestValuesBinaryMatchesText(t *testing.T) {
+	rs, textRow, binaryRow := benchmarkRelation()
+	text, err := rs.Values(1, textRow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin, err := rs.Values(1, binaryRow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range text {
+		if !valueEqual(value.Get(), bin[name].Get()) {
+			t.Errorf("%s: text %v, binary %v", name, value.Get(), bin[name].Get())
+		}
+	}
+}
+
+func TestValuesBinaryBuiltin(t *testing.T) {
+	be64 := func(x int64) []byte {
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, uint64(x))
+		return b
+	}
+	timetz := append(be64(4*3600000000+5*60000000+6789000), 0, 0, 0, 0)
+	offset := int32(-8 * 3600)
+	binary.BigEndian.PutUint32(timetz[8:], uint32(offset))
+	tests := []struct {
+		oid  uint32
+		src  []byte
+		want interface{}
+	}{
+		{moneyOID, be64(100000), int64(100000)},
+		{moneyOID, be64(-5), int64(-5)},
+		{timeOID, be64(4*3600000000 + 5*60000000 + 6000000), "04:05:06"},
+		{timeOID, be64(23*3600000000 + 59*60000000 + 59999999), "23:59:59.999999"},
+		{timetzOID, timetz, "04:05:06.789+08"},
+	}
+	for _, tt := range tests {
+		rs := NewRelationSet()
+		rs.Add(Relation{ID: 1, Columns: []Column{{Name: "v", Type: tt.oid}}})
+		values, err := rs.Values(1, []Tuple{{Flag: 'b', Value: tt.src}})
+		if err != nil {
+			t.Errorf("oid %d: %s", tt.oid, err)
+			continue
+		}
+		if got := values["v"].Get(); got != tt.want {
+			t.Errorf("oid %d: got %#v, want %#v", tt.oid, got, tt.want)
+		}
+	}
+}
+
+func TestValuesBinaryUnknownType(t *testing.T) {
+	// "char"、record、tsvector(3614)及扩展类型保留原始字节
+	for _, oid := range []uint32{pgtype.CharOID, pgtype.RecordOID, 3614, 99999} {
+		rs := NewRelationSet()
+		rs.Add(Relation{ID: 1, Columns: []Column{{Name: "v", Type: oid}}})
+		values, err := rs.Values(1, []Tuple{{Flag: 'b', Value: []byte{1, 2, 3}}})
+		if err != nil {
+			t.Errorf("oid %d: %s", oid, err)
+			continue
+		}
+		if got, ok := values["v"].Get().([]byte); !ok || !bytes.Equal(got, []byte{1, 2, 3}) {
+			t.Errorf("oid %d: got %#v, want raw bytes", oid, values["v"].Get())
+		}
+		if got := Normalize(values["v"]); got != "AQID" {
+			t.Errorf("oid %d: normalized %#v, want base64", oid, got)
+		}
+	}
+	// 注册的解码器优先
+	rs := NewRelationSet()
+	rs.Add(Relation{ID: 1, Columns: []Column{{Name: "v", Type: 99999}}})
+	rs.RegisterOIDDecoder(99999, func() DecoderValue { return &pgtype.Int4{} })
+	values, err := rs.Values(1, []Tuple{{Flag: 'b', Value: []byte{0, 0, 0, 7}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := values["v"].Get(); got != int32(7) {
+		t.Fatalf("got %#v, want 7", got)
+	}
+	// 注册的解码器不支持二进制格式
+	rs.RegisterOIDDecoder(99999, func() DecoderValue { return textOnly{&pgtype.Text{}} })
+	if _, err = rs.Values(1, []Tuple{{Flag: 'b', Value: []byte{1}}}); err == nil {
+		t.Error("expected error for decoder without DecodeBinary")
+	}
+}
+
+// 仅支持文本格式的解码器
+type textOnly struct {
+	DecoderValue
+}
+
+func BenchmarkValuesText(b *testing.B) {
+	rs, row, _ := benchmarkRelation()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := rs.Values(1, row); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkValuesBinary(b *testing.B) {
+	rs, _, row := benchmarkRelation()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := rs.Values(1, row); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestDiffRelation(t *testing.T) {
+	base := Relation{ID: 1, Namespace: "public", Name: "t", Replica: 'd', Columns: []Column{
+		{Key: true, Name: "id", Type: pgtype.Int4OID, Mode: 0xffffffff},
+		{Name: "name", Type: pgtype.VarcharOID, Mode: 36},
+		{Name: "note", Type: pgtype.TextOID, Mode: 0xffffffff},
+	}}
+	alter := func(f func(r *Relation)) Relation {
+		r := base
+		r.Columns = append([]Column{}, base.Columns...)
+		f(&r)
+		return r
+	}
+	names := func(columns []Column) (res []string) {
+		for _, col := range columns {
+			res = append(res, col.Name)
+		}
+		return
+	}
+	tests := []struct {
+		name  string
+		r     Relation
+		check func(c *SchemaChange) bool
+	}{
+		{"unchanged", base, func(c *SchemaChange) bool { return c == nil }},
+		{"added", alter(func(r *Relation) {
+			r.Columns = append(r.Columns, Column{Name: "x", Type: pgtype.Int8OID})
+		}), func(c *SchemaChange) bool {
+			return reflect.DeepEqual(names(c.Added), []string{"x"}) && c.Dropped == nil && c.Retyped == nil && !c.KeyChanged
+		}},
+		{"dropped", alter(func(r *Relation) {
+			r.Columns = r.Columns[:2]
+		}), func(c *SchemaChange) bool {
+			return reflect.DeepEqual(names(c.Dropped), []string{"note"}) && c.Added == nil
+		}},
+		{"renamed column", alter(func(r *Relation) {
+			r.Columns[2].Name = "remark"
+		}), func(c *SchemaChange) bool {
+			return reflect.DeepEqual(names(c.Added), []string{"remark"}) && reflect.DeepEqual(names(c.Dropped), []string{"note"})
+		}},
+		{"retyped", alter(func(r *Relation) {
+			r.Columns[2].Type = pgtype.JSONBOID
+		}), func(c *SchemaChange) bool {
+			return reflect.DeepEqual(c.Retyped, []ColumnRetype{{Name: "note", OldType: pgtype.TextOID, OldMode: 0xffffffff, Type: pgtype.JSONBOID, Mode: 0xffffffff}})
+		}},
+		{"type modifier", alter(func(r *Relation) {
+			r.Columns[1].Mode = 68
+		}), func(c *SchemaChange) bool {
+			return len(c.Retyped) == 1 && c.Retyped[0].OldMode == 36 && c.Retyped[0].Mode == 68
+		}},
+		{"key changed", alter(func(r *Relation) {
+			r.Columns[1].Key = true
+		}), func(c *SchemaChange) bool {
+			return c.KeyChanged && reflect.DeepEqual(c.OldKeys, []string{"id"}) && reflect.DeepEqual(c.Keys, []string{"id", "name"}) && c.Retyped == nil
+		}},
+		{"replica identity", alter(func(r *Relation) {
+			r.Replica = 'f'
+			for i := range r.Columns {
+				r.Columns[i].Key = true
+			}
+		}), func(c *SchemaChange) bool {
+			return c.OldReplica == 'd' && c.Replica == 'f' && c.KeyChanged
+		}},
+		{"replica identity only", alter(func(r *Relation) {
+			r.Replica = 'n'
+		}), func(c *SchemaChange) bool {
+			return c.OldReplica == 'd' && c.Replica == 'n' && !c.KeyChanged && c.Added == nil && c.Dropped == nil
+		}},
+		{"renamed table", alter(func(r *Relation) {
+			r.Namespace, r.Name = "archive", "t_old"
+		}), func(c *SchemaChange) bool {
+			return c.OldSchemaName == "public" && c.OldTableName == "t"
+		}},
+	}
+	for _, tt := range tests {
+		if c := diffRelation(base, tt.r); !tt.check(c) {
+			t.Errorf("%s: got %+v", tt.name, c)
+		}
+	}
+}
+
+// money的文本格式(依赖lc_monetary)与二进制格式解码为相同的最小货币单位整数
+func TestMoney(t *testing.T) {
+	tests := []struct {
+		text string
+		want int64
+	}{
+		{"$1,000.00", 100000},
+		{"-$1,234,567.89", -123456789},
+		{"($5.25)", -525},
+		{"1.000,00 €", 100000},
+		{"-1 000,05 kr", -100005},
+		{"¥1,000", 1000},
+		{"-$92,233,720,368,547,758.08", -9223372036854775808},
+	}
+	for _, tt := range tests {
+		var text Money
+		if err := text.DecodeText(nil, []byte(tt.text)); err != nil {
+			t.Errorf("%q: %s", tt.text, err)
+			continue
+		}
+		src := make([]byte, 8)
+		binary.BigEndian.PutUint64(src, uint64(tt.want))
+		var bin Money
+		if err := bin.DecodeBinary(nil, src); err != nil {
+			t.Fatal(err)
+		}
+		if text.Get() != tt.want || bin.Get() != tt.want || Normalize(&text) != tt.want {
+			t.Errorf("%q: text %#v, binary %#v, want %d", tt.text, text.Get(), bin.Get(), tt.want)
+		}
+	}
+	for _, text := range []string{"$", "", "$92,233,720,368,547,758.08"} {
+		var m Money
+		if err := m.DecodeText(nil, []byte(text)); err == nil {
+			t.Errorf("%q: expected error", text)
+		}
+	}
+}
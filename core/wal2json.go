@@ -0,0 +1,160 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx"
+)
+
+// Wal2Json wal2json插件(format-version 2)，每条wal消息为一个JSON对象
+// 不使用发布流，表过滤可通过Args传入"add-tables"等插件参数，复制源需wal2json 2.4+并传入"include-origin"
+// 列值为JSON原生类型：整数为int64，real/double precision为float64，numeric等保留字符串，money与pgoutput一致为最小货币单位的int64
+type Wal2Json struct {
+	// 附加的START_REPLICATION插件参数，如 `"add-tables" 'public.a,public.b'`
+	Args []string
+
+	tx *Transaction
+}
+
+type wal2jsonColumn struct {
+	Name  string      `json:"name"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+type wal2jsonMessage struct {
+	Action        string           `json:"action"`
+	Xid           uint32           `json:"xid"`
+	Lsn           string           `json:"lsn"`
+	NextLsn       string           `json:"nextlsn"`
+	Timestamp     string           `json:"timestamp"`
+	Origin        string           `json:"origin"`
+	Schema        string           `json:"schema"`
+	Table         string           `json:"table"`
+	Columns       []wal2jsonColumn `json:"columns"`
+	Identity      []wal2jsonColumn `json:"identity"`
+	Transactional bool             `json:"transactional"`
+	Prefix        string           `json:"prefix"`
+	Content       string           `json:"content"`
+}
+
+func (w *Wal2Json) Name() string {
+	return "wal2json"
+}
+
+func (w *Wal2Json) SlotOptions(t *Replication) []string {
+	return []string{"NOEXPORT_SNAPSHOT"}
+}
+
+func (w *Wal2Json) Reset() {
+	w.tx = nil
+}
+
+func (w *Wal2Json) StartArgs(t *Replication) []string {
+	args := []string{
+		`"format-version" '2'`,
+		`"include-xids" '1'`,
+		`"include-timestamp" '1'`,
+		`"include-lsn" '1'`,
+	}
+	return append(args, w.Args...)
+}
+
+func (w *Wal2Json) Decode(t *Replication, message *pgx.WalMessage) (res []ReplicationMessage, err error) {
+	var v wal2jsonMessage
+	d := json.NewDecoder(bytes.NewReader(message.WalData))
+	d.UseNumber()
+	if err = d.Decode(&v); err != nil {
+		return nil, fmt.Errorf("invalid wal2json message: %s", err)
+	}
+	switch v.Action {
+	case "B":
+		w.tx = &Transaction{Xid: v.Xid, BeginLsn: message.WalStart, Origin: v.Origin}
+		w.tx.CommitTime, _ = parseTimestamptz(v.Timestamp)
+	case "C":
+		tx := w.tx
+		if tx == nil {
+			tx = &Transaction{Xid: v.Xid}
+		}
+		if lsn, er := pgx.ParseLSN(v.Lsn); er == nil {
+			tx.CommitLsn = lsn
+		}
+		if lsn, er := pgx.ParseLSN(v.NextLsn); er == nil {
+			tx.EndLsn = lsn
+		}
+		if ts, er := parseTimestamptz(v.Timestamp); er == nil {
+			tx.CommitTime = ts
+		}
+		w.tx = nil
+		res = append(res, ReplicationMessage{EventType: EventType_COMMIT, Xid: tx.Xid, Lsn: message.WalStart, Tx: tx})
+	case "I", "U", "D", "T":
+		m := ReplicationMessage{SchemaName: v.Schema, TableName: v.Table}
+		switch v.Action {
+		case "I":
+			m.EventType = EventType_INSERT
+			m.Body = wal2jsonValues(v.Columns)
+		case "U":
+			m.EventType = EventType_UPDATE
+			m.Body = wal2jsonValues(v.Columns)
+			if v.Identity != nil {
+				m.OldBody = wal2jsonValues(v.Identity)
+				m.OldKeyOnly = len(v.Identity) < len(v.Columns)
+				m.Columns, m.Changes = diffColumns(m.Body, m.OldBody)
+			}
+		case "D":
+			// 无法区分复制标识列与整行
+			m.EventType = EventType_DELETE
+			m.Body = wal2jsonValues(v.Identity)
+			m.OldBody = wal2jsonValues(v.Identity)
+		case "T":
+			m.EventType = EventType_TRUNCATE
+		}
+		res = append(res, withTransaction(m, w.tx, message.WalStart))
+	case "M":
+		lm := ReplicationMessage{
+			EventType:     EventType_MESSAGE,
+			Transactional: v.Transactional,
+			Prefix:        v.Prefix,
+			Content:       []byte(v.Content),
+		}
+		lm.Lsn = message.WalStart
+		if v.Transactional {
+			lm = withTransaction(lm, w.tx, message.WalStart)
+		}
+		res = append(res, lm)
+	default:
+		return nil, fmt.Errorf("invalid wal2json message: unknown action %q", v.Action)
+	}
+	return
+}
+
+// 列值转换，整数为int64，real/double precision为float64，money为最小货币单位的int64，其余数值保留字符串
+func wal2jsonValues(columns []wal2jsonColumn) map[string]interface{} {
+	values := make(map[string]interface{}, len(columns))
+	for _, col := range columns {
+		value := col.Value
+		if s, ok := value.(string); ok && col.Type == "money" {
+			if n, err := moneyMinorUnits(s); err == nil {
+				value = n
+			}
+		}
+		if n, ok := value.(json.Number); ok {
+			value = n.String()
+			switch {
+			case col.Type == "smallint" || col.Type == "integer" || col.Type == "bigint" || col.Type == "oid":
+				if i, err := n.Int64(); err == nil {
+					value = i
+				}
+			case col.Type == "real" || strings.HasPrefix(col.Type, "double"):
+				if f, err := n.Float64(); err == nil {
+					value = f
+				}
+			}
+		}
+		values[col.Name] = value
+	}
+	return values
+}
@@ -2,7 +2,10 @@ module github.com/cube-group/pg-replication
 
 go 1.18
 
-require github.com/jackc/pgx v3.6.2+incompatible
+require (
+	github.com/jackc/pgx v3.6.2+incompatible
+	github.com/shopspring/decimal v1.3.1
+)
 
 require (
 	github.com/cockroachdb/apd v1.1.0 // indirect
@@ -10,7 +13,6 @@ require (
 	github.com/jackc/fake v0.0.0-20150926172116-812a484cc733 // indirect
 	github.com/lib/pq v1.10.7 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
-	github.com/shopspring/decimal v1.3.1 // indirect
 	golang.org/x/crypto v0.6.0 // indirect
 	golang.org/x/text v0.7.0 // indirect
 )
@@ -1,372 +1,376 @@
 package util
 
 import (
-	"errors"
+	"encoding/json"
 	"fmt"
+	"math"
+	"math/big"
 	"reflect"
 	"strconv"
+	"time"
+
+	"github.com/shopspring/decimal"
 )
 
-func GetTypeName(v interface{}) {
-	valueOf := reflect.ValueOf(v)
-	if valueOf.Type().Kind() == reflect.Ptr {
-		fmt.Println(reflect.Indirect(valueOf).Type().Name())
-	} else {
-		fmt.Println(valueOf.Type().Name())
+// GetTypeName 类型名称，指针返回所指向的类型名称，nil返回空字符串
+func GetTypeName(v interface{}) string {
+	rt := reflect.TypeOf(v)
+	if rt == nil {
+		return ""
+	}
+	for rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
 	}
+	return rt.Name()
 }
 
 /*
 类型转换通用函数
 Must开头的为必须返回相应类型的值,第二个参数为默认值，转换失败会传了默认值会返回默认值
 非Must开头的会多返回err,不为nil则是转型失败
+整数转换会检查目标类型的范围，超出范围返回err；浮点数转整数时截断小数部分
 */
 
-func Int(data interface{}) (int, error) {
-	switch data.(type) {
+// Signed 有符号整数类型
+type Signed interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64
+}
+
+// Unsigned 无符号整数类型
+type Unsigned interface {
+	~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64
+}
+
+// Integer 整数类型
+type Integer interface {
+	Signed | Unsigned
+}
+
+// Float 浮点数类型
+type Float interface {
+	~float32 | ~float64
+}
+
+// ToInteger 转换为整数类型T，支持字符串、json.Number及数值类型
+func ToInteger[T Integer](data interface{}) (T, error) {
+	var zero T
+	rt := reflect.TypeOf(zero)
+	signed := rt.Kind() >= reflect.Int && rt.Kind() <= reflect.Int64
+	bits := rt.Bits()
+	if n, ok := data.(json.Number); ok {
+		data = n.String()
+	}
+	switch v := data.(type) {
 	case string:
-		i, err := strconv.ParseInt(data.(string), 10, 64)
-		return int(i), err
-	case float32, float64:
-		return int(reflect.ValueOf(data).Float()), nil
-	case int, int8, int16, int32, int64:
-		return int(reflect.ValueOf(data).Int()), nil
-	case uint, uint8, uint16, uint32, uint64:
-		return int(reflect.ValueOf(data).Uint()), nil
+		if signed {
+			i, err := strconv.ParseInt(v, 10, bits)
+			if err != nil {
+				return zero, convertError(data, zero, err)
+			}
+			return T(i), nil
+		}
+		u, err := strconv.ParseUint(v, 10, bits)
+		if err != nil {
+			return zero, convertError(data, zero, err)
+		}
+		return T(u), nil
+	}
+	rv := reflect.ValueOf(data)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := rv.Int()
+		if (signed && reflect.Zero(rt).OverflowInt(i)) || (!signed && (i < 0 || reflect.Zero(rt).OverflowUint(uint64(i)))) {
+			return zero, convertError(data, zero, errRange)
+		}
+		return T(i), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := rv.Uint()
+		if (signed && (u > math.MaxInt64 || reflect.Zero(rt).OverflowInt(int64(u)))) || (!signed && reflect.Zero(rt).OverflowUint(u)) {
+			return zero, convertError(data, zero, errRange)
+		}
+		return T(u), nil
+	case reflect.Float32, reflect.Float64:
+		f := math.Trunc(rv.Float())
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return zero, convertError(data, zero, errRange)
+		}
+		if signed {
+			if f < math.MinInt64 || f >= math.MaxInt64 || reflect.Zero(rt).OverflowInt(int64(f)) {
+				return zero, convertError(data, zero, errRange)
+			}
+			return T(int64(f)), nil
+		}
+		if f < 0 || f >= math.MaxUint64 || reflect.Zero(rt).OverflowUint(uint64(f)) {
+			return zero, convertError(data, zero, errRange)
+		}
+		return T(uint64(f)), nil
 	}
-	return 0, errors.New("invalid value convert")
+	return zero, convertError(data, zero, nil)
 }
 
-func MustInt(data interface{}, defaultValue ...int) int {
-	if s, ok := Int(data); ok == nil {
-		return s
+// ToFloat 转换为浮点数类型T，支持字符串、json.Number及数值类型
+func ToFloat[T Float](data interface{}) (T, error) {
+	var zero T
+	bits := reflect.TypeOf(zero).Bits()
+	if n, ok := data.(json.Number); ok {
+		data = n.String()
+	}
+	if s, ok := data.(string); ok {
+		f, err := strconv.ParseFloat(s, bits)
+		if err != nil {
+			return zero, convertError(data, zero, err)
+		}
+		return T(f), nil
+	}
+	rv := reflect.ValueOf(data)
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if bits == 32 && !math.IsInf(f, 0) && math.Abs(f) > math.MaxFloat32 {
+			return zero, convertError(data, zero, errRange)
+		}
+		return T(f), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return T(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return T(rv.Uint()), nil
+	}
+	return zero, convertError(data, zero, nil)
+}
+
+// Must 转换失败时返回默认值(未传入则为零值)
+func Must[T any](value T, err error, defaultValue ...T) T {
+	if err == nil {
+		return value
 	}
 	if len(defaultValue) == 1 {
 		return defaultValue[0]
 	}
-	return 0
+	var zero T
+	return zero
 }
 
-func Int8(data interface{}) (int8, error) {
-	switch data.(type) {
-	case string:
-		i, err := strconv.ParseInt(data.(string), 10, 8)
-		return int8(i), err
-	case float32, float64:
-		return int8(reflect.ValueOf(data).Float()), nil
-	case int, int8, int16, int32, int64:
-		return int8(reflect.ValueOf(data).Int()), nil
-	case uint, uint8, uint16, uint32, uint64:
-		return int8(reflect.ValueOf(data).Uint()), nil
+var errRange = fmt.Errorf("value out of range")
+
+func convertError(data interface{}, target interface{}, err error) error {
+	if err == nil {
+		return fmt.Errorf("cannot convert %T to %T", data, target)
 	}
-	return 0, errors.New("invalid value convert")
+	return fmt.Errorf("cannot convert %T(%v) to %T: %w", data, data, target, err)
+}
+
+func Int(data interface{}) (int, error) {
+	return ToInteger[int](data)
+}
+
+func MustInt(data interface{}, defaultValue ...int) int {
+	v, err := Int(data)
+	return Must(v, err, defaultValue...)
+}
+
+func Int8(data interface{}) (int8, error) {
+	return ToInteger[int8](data)
 }
 
 func MustInt8(data interface{}, defaultValue ...int8) int8 {
-	if s, ok := Int8(data); ok == nil {
-		return s
-	}
-	if len(defaultValue) == 1 {
-		return defaultValue[0]
-	}
-	return 0
+	v, err := Int8(data)
+	return Must(v, err, defaultValue...)
 }
 
 func Int16(data interface{}) (int16, error) {
-	switch data.(type) {
-	case string:
-		i, err := strconv.ParseInt(data.(string), 10, 8)
-		return int16(i), err
-	case float32, float64:
-		return int16(reflect.ValueOf(data).Float()), nil
-	case int, int8, int16, int32, int64:
-		return int16(reflect.ValueOf(data).Int()), nil
-	case uint, uint8, uint16, uint32, uint64:
-		return int16(reflect.ValueOf(data).Uint()), nil
-	}
-	return 0, errors.New("invalid value convert")
+	return ToInteger[int16](data)
 }
 
 func MustInt16(data interface{}, defaultValue ...int16) int16 {
-	if s, ok := Int16(data); ok == nil {
-		return s
-	}
-	if len(defaultValue) == 1 {
-		return defaultValue[0]
-	}
-	return 0
+	v, err := Int16(data)
+	return Must(v, err, defaultValue...)
 }
 
 func Int32(data interface{}) (int32, error) {
-	switch data.(type) {
-	case string:
-		i, err := strconv.ParseInt(data.(string), 10, 32)
-		return int32(i), err
-	case float32, float64:
-		return int32(reflect.ValueOf(data).Float()), nil
-	case int, int8, int16, int32, int64:
-		return int32(reflect.ValueOf(data).Int()), nil
-	case uint, uint8, uint16, uint32, uint64:
-		return int32(reflect.ValueOf(data).Uint()), nil
-	}
-	return 0, errors.New("invalid value convert")
+	return ToInteger[int32](data)
 }
 
 func MustInt32(data interface{}, defaultValue ...int32) int32 {
-	if s, ok := Int32(data); ok == nil {
-		return s
-	}
-	if len(defaultValue) == 1 {
-		return defaultValue[0]
-	}
-	return 0
+	v, err := Int32(data)
+	return Must(v, err, defaultValue...)
 }
 
 func Int64(data interface{}) (int64, error) {
-	switch data.(type) {
-	case string:
-		i, err := strconv.ParseInt(data.(string), 10, 64)
-		return int64(i), err
-	case float32, float64:
-		return int64(reflect.ValueOf(data).Float()), nil
-	case int, int8, int16, int32, int64:
-		return int64(reflect.ValueOf(data).Int()), nil
-	case uint, uint8, uint16, uint32, uint64:
-		return int64(reflect.ValueOf(data).Uint()), nil
-	}
-	return 0, errors.New("invalid value convert")
+	return ToInteger[int64](data)
 }
 
 func MustInt64(data interface{}, defaultValue ...int64) int64 {
-	if s, ok := Int64(data); ok == nil {
-		return s
-	}
-	if len(defaultValue) == 1 {
-		return defaultValue[0]
-	}
-	return 0
+	v, err := Int64(data)
+	return Must(v, err, defaultValue...)
 }
 
 func Uint(data interface{}) (uint, error) {
-	switch data.(type) {
-	case string:
-		i, err := strconv.ParseUint(data.(string), 10, 64)
-		return uint(i), err
-	case float32, float64:
-		return uint(reflect.ValueOf(data).Float()), nil
-	case int, int8, int16, int32, int64:
-		return uint(reflect.ValueOf(data).Int()), nil
-	case uint, uint8, uint16, uint32, uint64:
-		return uint(reflect.ValueOf(data).Uint()), nil
-	}
-	return 0, errors.New("invalid value convert")
+	return ToInteger[uint](data)
 }
 
 func MustUint(data interface{}, defaultValue ...uint) uint {
-	if s, ok := Uint(data); ok == nil {
-		return s
-	}
-	if len(defaultValue) == 1 {
-		return defaultValue[0]
-	}
-	return 0
+	v, err := Uint(data)
+	return Must(v, err, defaultValue...)
 }
 
 func Uint8(data interface{}) (uint8, error) {
-	switch data.(type) {
-	case string:
-		i, err := strconv.ParseUint(data.(string), 10, 64)
-		return uint8(i), err
-	case float32, float64:
-		return uint8(reflect.ValueOf(data).Float()), nil
-	case int, int8, int16, int32, int64:
-		return uint8(reflect.ValueOf(data).Int()), nil
-	case uint, uint8, uint16, uint32, uint64:
-		return uint8(reflect.ValueOf(data).Uint()), nil
-	}
-	return 0, errors.New("invalid value convert")
+	return ToInteger[uint8](data)
 }
 
 func MustUint8(data interface{}, defaultValue ...uint8) uint8 {
-	if s, ok := Uint8(data); ok == nil {
-		return s
-	}
-	if len(defaultValue) == 1 {
-		return defaultValue[0]
-	}
-	return 0
+	v, err := Uint8(data)
+	return Must(v, err, defaultValue...)
 }
 
 func Uint16(data interface{}) (uint16, error) {
-	switch data.(type) {
-	case string:
-		i, err := strconv.ParseUint(data.(string), 10, 64)
-		return uint16(i), err
-	case float32, float64:
-		return uint16(reflect.ValueOf(data).Float()), nil
-	case int, int8, int16, int32, int64:
-		return uint16(reflect.ValueOf(data).Int()), nil
-	case uint, uint8, uint16, uint32, uint64:
-		return uint16(reflect.ValueOf(data).Uint()), nil
-	}
-	return 0, errors.New("invalid value convert")
+	return ToInteger[uint16](data)
 }
 
 func MustUint16(data interface{}, defaultValue ...uint16) uint16 {
-	if s, ok := Uint16(data); ok == nil {
-		return s
-	}
-	if len(defaultValue) == 1 {
-		return defaultValue[0]
-	}
-	return 0
+	v, err := Uint16(data)
+	return Must(v, err, defaultValue...)
 }
 
 func Uint32(data interface{}) (uint32, error) {
-	switch data.(type) {
-	case string:
-		i, err := strconv.ParseUint(data.(string), 10, 64)
-		return uint32(i), err
-	case float32, float64:
-		return uint32(reflect.ValueOf(data).Float()), nil
-	case int, int8, int16, int32, int64:
-		return uint32(reflect.ValueOf(data).Int()), nil
-	case uint, uint8, uint16, uint32, uint64:
-		return uint32(reflect.ValueOf(data).Uint()), nil
-	}
-	return 0, errors.New("invalid value convert")
+	return ToInteger[uint32](data)
 }
 
 func MustUint32(data interface{}, defaultValue ...uint32) uint32 {
-	if s, ok := Uint32(data); ok == nil {
-		return s
-	}
-	if len(defaultValue) == 1 {
-		return defaultValue[0]
-	}
-	return 0
+	v, err := Uint32(data)
+	return Must(v, err, defaultValue...)
 }
 
 func Uint64(data interface{}) (uint64, error) {
-	switch data.(type) {
-	case string:
-		i, err := strconv.ParseUint(data.(string), 10, 64)
-		return uint64(i), err
-	case float32, float64:
-		return uint64(reflect.ValueOf(data).Float()), nil
-	case int, int8, int16, int32, int64:
-		return uint64(reflect.ValueOf(data).Int()), nil
-	case uint, uint8, uint16, uint32, uint64:
-		return uint64(reflect.ValueOf(data).Uint()), nil
-	}
-	return 0, errors.New("invalid value convert")
+	return ToInteger[uint64](data)
 }
 
 func MustUint64(data interface{}, defaultValue ...uint64) uint64 {
-	if s, ok := Uint64(data); ok == nil {
-		return s
-	}
-	if len(defaultValue) == 1 {
-		return defaultValue[0]
-	}
-	return 0
+	v, err := Uint64(data)
+	return Must(v, err, defaultValue...)
 }
 
 func Float32(data interface{}) (float32, error) {
-	switch data.(type) {
-	case string:
-		res, err := strconv.ParseFloat(data.(string), 64)
-		return float32(res), err
-	case float32, float64:
-		return float32(reflect.ValueOf(data).Float()), nil
-	case int, int8, int16, int32, int64:
-		return float32(reflect.ValueOf(data).Int()), nil
-	case uint, uint8, uint16, uint32, uint64:
-		return float32(reflect.ValueOf(data).Uint()), nil
-	}
-	return 0, errors.New("invalid value convert")
+	return ToFloat[float32](data)
 }
 
 func MustFloat32(data interface{}, defaultValue ...float32) float32 {
-	if s, ok := Float32(data); ok == nil {
-		return s
-	}
-	if len(defaultValue) == 1 {
-		return defaultValue[0]
-	}
-	return 0
+	v, err := Float32(data)
+	return Must(v, err, defaultValue...)
 }
 
 func Float64(data interface{}) (float64, error) {
-	switch data.(type) {
-	case string:
-		return strconv.ParseFloat(data.(string), 64)
-	case float32, float64:
-		return reflect.ValueOf(data).Float(), nil
-	case int, int8, int16, int32, int64:
-		return float64(reflect.ValueOf(data).Int()), nil
-	case uint, uint8, uint16, uint32, uint64:
-		return float64(reflect.ValueOf(data).Uint()), nil
-	}
-	return 0, errors.New("invalid value convert")
+	return ToFloat[float64](data)
 }
 
 func MustFloat64(data interface{}, defaultValue ...float64) float64 {
-	if s, ok := Float64(data); ok == nil {
-		return s
-	}
-	if len(defaultValue) == 1 {
-		return defaultValue[0]
-	}
-	return 0
+	v, err := Float64(data)
+	return Must(v, err, defaultValue...)
 }
 
+// Bool 支持bool及strconv.ParseBool可解析的字符串(含PostgreSQL的"t"/"f")
 func Bool(data interface{}) (bool, error) {
-	if s, ok := data.(bool); ok {
-		return s, nil
+	switch v := data.(type) {
+	case bool:
+		return v, nil
+	case string:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, convertError(data, false, err)
+		}
+		return b, nil
 	}
-	return false, errors.New("invalid value convert")
+	return false, convertError(data, false, nil)
 }
 
 func MustBool(data interface{}, defaultValue ...bool) bool {
-	if s, ok := Bool(data); ok == nil {
-		return s
-	}
-	if len(defaultValue) == 1 {
-		return defaultValue[0]
-	}
-	return false
+	v, err := Bool(data)
+	return Must(v, err, defaultValue...)
 }
 
+// String 支持字符串、[]byte、fmt.Stringer、数值及bool
 func String(data interface{}) (string, error) {
-	return fmt.Sprintf("%v", data), nil
-	if s, ok := data.(string); ok {
-		return s, nil
-	}
-	return "", errors.New("invalid value convert")
+	switch v := data.(type) {
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	case json.Number:
+		return v.String(), nil
+	case fmt.Stringer:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	rv := reflect.ValueOf(data)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	case reflect.Float32:
+		return strconv.FormatFloat(rv.Float(), 'f', -1, 32), nil
+	case reflect.Float64:
+		return strconv.FormatFloat(rv.Float(), 'f', -1, 64), nil
+	case reflect.String:
+		return rv.String(), nil
+	}
+	return "", convertError(data, "", nil)
 }
+
 func MustString(data interface{}, defaultValue ...string) string {
-	if s, ok := String(data); ok == nil {
-		return s
-	}
-	if len(defaultValue) == 1 {
-		return defaultValue[0]
-	}
-	return ""
+	v, err := String(data)
+	return Must(v, err, defaultValue...)
 }
 
 func Bytes(data interface{}) ([]byte, error) {
-	if s, ok := data.(string); ok {
-		return []byte(s), nil
+	switch v := data.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	}
+	return nil, convertError(data, []byte(nil), nil)
+}
+
+// 支持的时间文本格式
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999Z07",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// Time 支持time.Time、RFC3339及PostgreSQL文本格式的字符串
+func Time(data interface{}) (time.Time, error) {
+	switch v := data.(type) {
+	case time.Time:
+		return v, nil
+	case *time.Time:
+		if v != nil {
+			return *v, nil
+		}
+	case string:
+		for _, layout := range timeLayouts {
+			if ts, err := time.Parse(layout, v); err == nil {
+				return ts, nil
+			}
+		}
+		return time.Time{}, convertError(data, time.Time{}, fmt.Errorf("unknown time format"))
 	}
-	return nil, errors.New("invalid value convert")
+	return time.Time{}, convertError(data, time.Time{}, nil)
+}
+
+func MustTime(data interface{}, defaultValue ...time.Time) time.Time {
+	v, err := Time(data)
+	return Must(v, err, defaultValue...)
 }
 
 func Slice(data interface{}) ([]interface{}, error) {
 	if a, ok := data.([]interface{}); ok {
 		return a, nil
 	}
-	return nil, errors.New("invalid value convert")
+	return nil, convertError(data, []interface{}(nil), nil)
 }
 
 func StringSlice(data interface{}) ([]string, error) {
@@ -375,14 +379,14 @@ func StringSlice(data interface{}) ([]string, error) {
 		return nil, err
 	}
 	retArr := make([]string, 0, len(arr))
-	for _, a := range arr {
+	for i, a := range arr {
 		if a == nil {
 			retArr = append(retArr, "")
 			continue
 		}
 		s, ok := a.(string)
 		if !ok {
-			return nil, errors.New("invalid value convert")
+			return nil, fmt.Errorf("element %d: %w", i, convertError(a, "", nil))
 		}
 		retArr = append(retArr, s)
 	}
@@ -390,11 +394,46 @@ func StringSlice(data interface{}) ([]string, error) {
 }
 
 func MustStringSlice(data interface{}, defaultValue ...[]string) []string {
-	if s, ok := StringSlice(data); ok == nil {
-		return s
+	v, err := StringSlice(data)
+	return Must(v, err, defaultValue...)
+}
+
+// Decimal 支持decimal.Decimal、字符串、json.Number及数值类型，字符串按十进制精确解析
+func Decimal(data interface{}) (decimal.Decimal, error) {
+	switch v := data.(type) {
+	case decimal.Decimal:
+		return v, nil
+	case *decimal.Decimal:
+		if v != nil {
+			return *v, nil
+		}
+	case json.Number:
+		return Decimal(v.String())
+	case string:
+		d, err := decimal.NewFromString(v)
+		if err != nil {
+			return decimal.Zero, convertError(data, decimal.Zero, err)
+		}
+		return d, nil
+	case float32:
+		return decimal.NewFromFloat32(v), nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return decimal.Zero, convertError(data, decimal.Zero, errRange)
+		}
+		return decimal.NewFromFloat(v), nil
 	}
-	if len(defaultValue) == 1 {
-		return defaultValue[0]
+	rv := reflect.ValueOf(data)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return decimal.NewFromInt(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return decimal.NewFromBigInt(new(big.Int).SetUint64(rv.Uint()), 0), nil
 	}
-	return nil
+	return decimal.Zero, convertError(data, decimal.Zero, nil)
+}
+
+func MustDecimal(data interface{}, defaultValue ...decimal.Decimal) decimal.Decimal {
+	v, err := Decimal(data)
+	return Must(v, err, defaultValue...)
 }
@@ -0,0 +1,249 @@
+package util
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestToInteger(t *testing.T) {
+	tests := []struct {
+		name string
+		conv func(interface{}) (interface{}, error)
+		data interface{}
+		want interface{}
+		ok   bool
+	}{
+		{"int8 max", wrap(Int8), int64(127), int8(127), true},
+		{"int8 overflow", wrap(Int8), int64(128), nil, false},
+		{"int8 underflow", wrap(Int8), -129, nil, false},
+		{"int8 string overflow", wrap(Int8), "200", nil, false},
+		{"int16 from uint", wrap(Int16), uint(32767), int16(32767), true},
+		{"int16 uint overflow", wrap(Int16), uint16(32768), nil, false},
+		{"int32 string", wrap(Int32), "-2147483648", int32(math.MinInt32), true},
+		{"int32 string overflow", wrap(Int32), "2147483648", nil, false},
+		{"int64 json.Number", wrap(Int64), json.Number("9007199254740993"), int64(9007199254740993), true},
+		{"int64 uint64 overflow", wrap(Int64), uint64(math.MaxUint64), nil, false},
+		{"int64 float truncate", wrap(Int64), -1.9, int64(-1), true},
+		{"int64 float overflow", wrap(Int64), 1e19, nil, false},
+		{"int64 NaN", wrap(Int64), math.NaN(), nil, false},
+		{"int64 Inf", wrap(Int64), math.Inf(1), nil, false},
+		{"int string invalid", wrap(Int), "12a", nil, false},
+		{"int string decimal", wrap(Int), "1.5", nil, false},
+		{"int bool", wrap(Int), true, nil, false},
+		{"int nil", wrap(Int), nil, nil, false},
+		{"uint8 negative", wrap(Uint8), -1, nil, false},
+		{"uint8 max", wrap(Uint8), 255.9, uint8(255), true},
+		{"uint8 float overflow", wrap(Uint8), 256.0, nil, false},
+		{"uint16 string", wrap(Uint16), "65535", uint16(65535), true},
+		{"uint32 string negative", wrap(Uint32), "-1", nil, false},
+		{"uint64 max", wrap(Uint64), uint64(math.MaxUint64), uint64(math.MaxUint64), true},
+		{"uint64 float negative", wrap(Uint64), -0.5, uint64(0), true},
+		{"uint64 float overflow", wrap(Uint64), 2e19, nil, false},
+		{"uint json.Number", wrap(Uint), json.Number("42"), uint(42), true},
+	}
+	for _, tt := range tests {
+		got, err := tt.conv(tt.data)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: error %v, want ok %v", tt.name, err, tt.ok)
+			continue
+		}
+		if tt.ok && got != tt.want {
+			t.Errorf("%s: got %#v, want %#v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestToFloat(t *testing.T) {
+	tests := []struct {
+		name string
+		conv func(interface{}) (interface{}, error)
+		data interface{}
+		want interface{}
+		ok   bool
+	}{
+		{"float64 string", wrap(Float64), "1.25", 1.25, true},
+		{"float64 string exponent", wrap(Float64), "-1e3", -1000.0, true},
+		{"float64 string Infinity", wrap(Float64), "Infinity", math.Inf(1), true},
+		{"float64 string invalid", wrap(Float64), "1,5", nil, false},
+		{"float64 json.Number", wrap(Float64), json.Number("0.5"), 0.5, true},
+		{"float64 int", wrap(Float64), int64(-3), -3.0, true},
+		{"float64 uint", wrap(Float64), uint8(3), 3.0, true},
+		{"float64 bool", wrap(Float64), false, nil, false},
+		{"float32 overflow", wrap(Float32), 1e39, nil, false},
+		{"float32 string overflow", wrap(Float32), "1e39", nil, false},
+		{"float32 Inf", wrap(Float32), math.Inf(-1), float32(math.Inf(-1)), true},
+		{"float32 float64", wrap(Float32), 0.5, float32(0.5), true},
+	}
+	for _, tt := range tests {
+		got, err := tt.conv(tt.data)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: error %v, want ok %v", tt.name, err, tt.ok)
+			continue
+		}
+		if tt.ok && got != tt.want {
+			t.Errorf("%s: got %#v, want %#v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStringBool(t *testing.T) {
+	strings := []struct {
+		data interface{}
+		want string
+		ok   bool
+	}{
+		{"a", "a", true},
+		{[]byte("b"), "b", true},
+		{json.Number("1.50"), "1.50", true},
+		{decimal.RequireFromString("1.50"), "1.5", true},
+		{true, "true", true},
+		{int16(-7), "-7", true},
+		{uint64(math.MaxUint64), "18446744073709551615", true},
+		{float32(0.1), "0.1", true},
+		{1e21, "1000000000000000000000", true},
+		{nil, "", false},
+		{[]int{1}, "", false},
+	}
+	for _, tt := range strings {
+		got, err := String(tt.data)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("String(%#v): got %q, %v, want %q", tt.data, got, err, tt.want)
+		}
+	}
+	bools := []struct {
+		data interface{}
+		want bool
+		ok   bool
+	}{
+		{true, true, true},
+		{"t", true, true},
+		{"f", false, true},
+		{"TRUE", true, true},
+		{"yes", false, false},
+		{1, false, false},
+	}
+	for _, tt := range bools {
+		got, err := Bool(tt.data)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("Bool(%#v): got %v, %v, want %v", tt.data, got, err, tt.want)
+		}
+	}
+}
+
+func TestDecimal(t *testing.T) {
+	d := decimal.RequireFromString("3.14")
+	tests := []struct {
+		data interface{}
+		want string
+		ok   bool
+	}{
+		{"123456789012345678901234567890.123456789", "123456789012345678901234567890.123456789", true},
+		{"-0.10", "-0.1", true},
+		{"1e3", "1000", true},
+		{json.Number("12.50"), "12.5", true},
+		{d, "3.14", true},
+		{&d, "3.14", true},
+		{(*decimal.Decimal)(nil), "", false},
+		{0.1, "0.1", true},
+		{float32(0.5), "0.5", true},
+		{int8(-5), "-5", true},
+		{uint64(math.MaxUint64), "18446744073709551615", true},
+		{math.NaN(), "", false},
+		{math.Inf(1), "", false},
+		{"NaN", "", false},
+		{"$1,000.00", "", false},
+		{true, "", false},
+	}
+	for _, tt := range tests {
+		got, err := Decimal(tt.data)
+		if (err == nil) != tt.ok {
+			t.Errorf("Decimal(%#v): error %v, want ok %v", tt.data, err, tt.ok)
+			continue
+		}
+		if tt.ok && got.String() != tt.want {
+			t.Errorf("Decimal(%#v): got %s, want %s", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestTime(t *testing.T) {
+	ts := time.Date(2023, 1, 2, 3, 4, 5, 123456000, time.UTC)
+	tests := []struct {
+		data interface{}
+		want time.Time
+		ok   bool
+	}{
+		{ts, ts, true},
+		{&ts, ts, true},
+		{(*time.Time)(nil), time.Time{}, false},
+		{"2023-01-02T03:04:05.123456Z", ts, true},
+		{"2023-01-02T11:04:05.123456+08:00", ts, true},
+		{"2023-01-02 11:04:05.123456+08", ts, true},
+		{"2023-01-02 08:34:05.123456+05:30", ts, true},
+		{"2023-01-02 03:04:05.123456", ts, true},
+		{"2023-01-02", time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC), true},
+		{"infinity", time.Time{}, false},
+		{"02/01/2023", time.Time{}, false},
+		{int64(1672628645), time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, err := Time(tt.data)
+		if (err == nil) != tt.ok {
+			t.Errorf("Time(%#v): error %v, want ok %v", tt.data, err, tt.ok)
+			continue
+		}
+		if tt.ok && !got.Equal(tt.want) {
+			t.Errorf("Time(%#v): got %s, want %s", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestMust(t *testing.T) {
+	if got := MustInt8(300); got != 0 {
+		t.Errorf("MustInt8(300) = %d, want 0", got)
+	}
+	if got := MustInt8(300, -1); got != -1 {
+		t.Errorf("MustInt8(300, -1) = %d, want -1", got)
+	}
+	if got := MustFloat64("2.5", 1); got != 2.5 {
+		t.Errorf("MustFloat64(2.5, 1) = %v, want 2.5", got)
+	}
+	if got := MustStringSlice([]interface{}{"a", nil, 1}, []string{"x"}); len(got) != 1 || got[0] != "x" {
+		t.Errorf("MustStringSlice = %v, want default", got)
+	}
+	if got := MustStringSlice([]interface{}{"a", nil}); len(got) != 2 || got[1] != "" {
+		t.Errorf("MustStringSlice = %v", got)
+	}
+}
+
+func TestGetTypeName(t *testing.T) {
+	d := decimal.Zero
+	pd := &d
+	tests := []struct {
+		data interface{}
+		want string
+	}{
+		{1, "int"},
+		{d, "Decimal"},
+		{pd, "Decimal"},
+		{&pd, "Decimal"},
+		{[]int{}, ""},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		if got := GetTypeName(tt.data); got != tt.want {
+			t.Errorf("GetTypeName(%#v) = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+}
+
+// 将转换函数的结果统一为interface{}
+func wrap[T any](conv func(interface{}) (T, error)) func(interface{}) (interface{}, error) {
+	return func(data interface{}) (interface{}, error) {
+		return conv(data)
+	}
+}